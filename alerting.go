@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// alertMaxPending is the maximum number of events kept per notifier while it can't be reached, the oldest
+// are dropped beyond it.
+const alertMaxPending = 10000
+
+// alertNotifier delivers quota events, e.g. to a Pub/Sub topic, a webhook or PagerDuty.
+type alertNotifier interface {
+	// deliver sends the events in order and returns how many of them were delivered before a failure.
+	deliver(ctx context.Context, events []quotaEvent) (int, error)
+}
+
+// rejectedError is the failure of an event sending it again can't fix, e.g. a payload the notifier rejects,
+// the event is dropped rather than holding up the following ones.
+type rejectedError struct {
+	err error
+}
+
+func (e *rejectedError) Error() string {
+	return e.err.Error()
+}
+
+// alertEngine evaluates the quotas of a project after each of its refreshes and hands the resulting events
+// to the notifiers, for teams wanting quota alerts without writing Prometheus rules. Only the leader notifies,
+// so the replicas don't send the same alerts.
+type alertEngine struct {
+	watcher *quotaWatcher
+	leader  *leaderElector
+	outputs []*alertOutput
+	mutex   sync.Mutex // serializes the evaluations of the projects refreshed concurrently
+}
+
+// alertOutput queues the events of a notifier, so a slow or unreachable notifier neither holds up the
+// refreshes nor loses the events.
+type alertOutput struct {
+	sink     string // sink label of the push metrics
+	target   string // destination of the notifier in the logs
+	notifier alertNotifier
+	types    map[string]bool // event types delivered, nil for all
+	wake     chan struct{}
+
+	mutex   sync.Mutex
+	pending []quotaEvent
+}
+
+// newAlertEngine returns an engine alerting on the thresholds and critical thresholds of the config, and on
+// defaultThreshold and defaultCritical for the quotas without one when they are positive.
+func newAlertEngine(defaultThreshold, defaultCritical float64, leader *leaderElector) *alertEngine {
+	return &alertEngine{watcher: newQuotaWatcher(defaultThreshold, defaultCritical), leader: leader}
+}
+
+// add registers a notifier getting the events of the given types, all of them when none are given.
+// All notifiers are added before the engine runs.
+func (a *alertEngine) add(sink, target string, notifier alertNotifier, types ...string) {
+	o := &alertOutput{sink: sink, target: target, notifier: notifier, wake: make(chan struct{}, 1)}
+	if len(types) > 0 {
+		o.types = make(map[string]bool, len(types))
+		for _, t := range types {
+			o.types[t] = true
+		}
+	}
+	a.outputs = append(a.outputs, o)
+}
+
+// evaluate compares the last fetch of the exporter's project with the previous one and queues the events.
+// A nil engine does nothing.
+func (a *alertEngine) evaluate(e *Exporter) {
+	if a == nil {
+		return
+	}
+	a.mutex.Lock()
+	events := a.watcher.detect(e)
+	a.mutex.Unlock()
+	if len(events) == 0 || !a.leader.leading() {
+		return
+	}
+	for _, o := range a.outputs {
+		o.queue(events)
+	}
+}
+
+// run delivers the queued events of every notifier until ctx is done. Failed deliveries are retried every
+// retry interval, each delivery is bounded by it.
+func (a *alertEngine) run(ctx context.Context, retry time.Duration) {
+	var wg sync.WaitGroup
+	for _, o := range a.outputs {
+		wg.Add(1)
+		go func(o *alertOutput) {
+			defer wg.Done()
+			o.run(ctx, retry)
+		}(o)
+	}
+	wg.Wait()
+}
+
+func (o *alertOutput) queue(events []quotaEvent) {
+	o.mutex.Lock()
+	for _, event := range events {
+		if o.types == nil || o.types[event.Type] {
+			o.pending = append(o.pending, event)
+		}
+	}
+	if dropped := len(o.pending) - alertMaxPending; dropped > 0 {
+		pushDropped.WithLabelValues(o.sink).Add(float64(dropped))
+		log.Warnf("Dropped %d quota events which couldn't be delivered to %s", dropped, o.target)
+		o.pending = o.pending[dropped:]
+	}
+	o.mutex.Unlock()
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (o *alertOutput) run(ctx context.Context, retry time.Duration) {
+	ticker := time.NewTicker(retry)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.wake:
+		case <-ticker.C:
+		}
+		deliverCtx, cancel := context.WithTimeout(ctx, retry)
+		o.flush(deliverCtx)
+		cancel()
+	}
+}
+
+// flush delivers the pending events, those not delivered are kept in front of the ones queued meanwhile.
+// A rejected event is dropped and the delivery goes on with the next one.
+func (o *alertOutput) flush(ctx context.Context) {
+	o.mutex.Lock()
+	events := o.pending
+	o.pending = nil
+	o.mutex.Unlock()
+
+	for len(events) > 0 {
+		sent, err := o.notifier.deliver(ctx, events)
+		pushSamples.WithLabelValues(o.sink).Add(float64(sent))
+		if err == nil {
+			return
+		}
+		pushFailures.WithLabelValues(o.sink).Inc()
+		if rejected, ok := err.(*rejectedError); ok {
+			pushDropped.WithLabelValues(o.sink).Inc()
+			log.Errorf("Dropped a quota event rejected by %s: %v", o.target, rejected.err)
+			events = events[sent+1:]
+			continue
+		}
+		log.Errorf("Couldn't deliver %d quota events to %s: %v", len(events)-sent, o.target, err)
+		o.mutex.Lock()
+		o.pending = append(append([]quotaEvent(nil), events[sent:]...), o.pending...)
+		o.mutex.Unlock()
+		return
+	}
+}
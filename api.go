@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"go.opencensus.io/trace"
+	"golang.org/x/oauth2"
+
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// requestIDHeader carries the ID of a scrape, both on the web requests and on the Google API calls made for it.
+const requestIDHeader = "X-Request-Id"
+
+type requestIDKey struct{}
+
+// withRequestID returns a copy of ctx carrying the scrape request ID id.
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// requestID returns the scrape request ID of ctx, empty when there is none.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// contextLogger returns a logger adding the scrape request ID of ctx to the log lines.
+func contextLogger(ctx context.Context) *log.Entry {
+	if id := requestID(ctx); id != "" {
+		return log.WithField("request_id", id)
+	}
+	return log.NewEntry(log.StandardLogger())
+}
+
+// tagAPICall sets the scrape request ID of ctx on the header of a Google API call.
+func tagAPICall(ctx context.Context, header http.Header) {
+	if id := requestID(ctx); id != "" {
+		header.Set(requestIDHeader, id)
+	}
+}
+
+// apiErrorCode returns the HTTP status code of a failed Google API call,
+// or "network" when the request never got a response.
+func apiErrorCode(err error) string {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return strconv.Itoa(apiErr.Code)
+	}
+	return "network"
+}
+
+// apiErrorReason classifies a failed Google API call as permission_denied, not_found,
+// throttled, timeout, server_error or unknown.
+func apiErrorReason(err error) string {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.Code == http.StatusUnauthorized || apiErr.Code == http.StatusForbidden:
+			return "permission_denied"
+		case apiErr.Code == http.StatusNotFound:
+			return "not_found"
+		case apiErr.Code == http.StatusTooManyRequests:
+			return "throttled"
+		case apiErr.Code >= 500:
+			return "server_error"
+		}
+		return "unknown"
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return "timeout"
+	}
+	return "unknown"
+}
+
+// recordAPIError counts a failed Google API call in gcp_quota_api_errors_total.
+// scope is the kind of data requested: project, regions, metadata or metric_info.
+func recordAPIError(project, scope string, err error) {
+	apiErrors.WithLabelValues(project, scope, apiErrorCode(err)).Inc()
+}
+
+// observeAPICall counts a Google API call and records its duration. When the call
+// is part of a sampled trace, the trace ID is attached to the observation as an exemplar.
+func observeAPICall(ctx context.Context, project, scope string, start time.Time) {
+	apiCalls.WithLabelValues(project, scope).Inc()
+	observer := apiDuration.WithLabelValues(project, scope)
+	if span := trace.FromContext(ctx); span != nil && span.SpanContext().IsSampled() {
+		traceID := span.SpanContext().TraceID.String()
+		observer.(prometheus.ExemplarObserver).ObserveWithExemplar(time.Since(start).Seconds(), prometheus.Labels{"trace_id": traceID})
+		return
+	}
+	observer.Observe(time.Since(start).Seconds())
+}
+
+// callContext returns the context of a single Google API call, bounded by opts.apiTimeout.
+func (e *Exporter) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if e.opts.apiTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, e.opts.apiTimeout)
+}
+
+// sharedContext returns the context of a fetch shared by concurrent collects. It keeps the values of ctx, like
+// the request ID and trace of the collect starting it, but not its deadline and cancellation, so the fetch isn't
+// bound to that single scraper. It is bounded by opts.apiTimeout instead.
+func (e *Exporter) sharedContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return e.callContext(detachedContext{parent: ctx})
+}
+
+// detachedContext carries the values of its parent without its deadline and cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool)       { return time.Time{}, false }
+func (c detachedContext) Done() <-chan struct{}             { return nil }
+func (c detachedContext) Err() error                        { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// call issues the Google API call of scope made by do, retrying transient failures up to opts.retries
+// times with a jittered exponential backoff. Every try waits for the rate limits of opts.rateLimits.
+// It returns the number of calls made and the last error.
+func (e *Exporter) call(ctx context.Context, scope string, do func(ctx context.Context) error) (int, error) {
+	for attempt := 0; ; attempt++ {
+		waited, err := e.opts.rateLimits.wait(ctx, e.credentials)
+		if waited > 0 {
+			apiThrottle.WithLabelValues(e.project).Add(waited.Seconds())
+		}
+		if err != nil {
+			return attempt, err
+		}
+
+		hint := &retryHint{}
+		callCtx, cancel := e.callContext(context.WithValue(ctx, retryHintKey{}, hint))
+		start := time.Now()
+		err = do(callCtx)
+		cancel()
+		observeAPICall(ctx, e.project, scope, start)
+		if err == nil || attempt >= e.opts.retries || !retryable(ctx, err) {
+			return attempt + 1, err
+		}
+
+		wait := hint.wait()
+		if wait == 0 {
+			// Full jitter: a random wait up to the exponential backoff.
+			wait = time.Duration(rand.Int63n(int64(e.opts.retryBackoff<<attempt) + 1))
+		} else if wait > e.maxRetryWait() {
+			// A long Retry-After would hold up the scrape, and the fetch shared with the other scrapes.
+			return attempt + 1, err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return attempt + 1, err
+		}
+		reason := apiErrorReason(err)
+		if reason == "unknown" {
+			reason = apiErrorCode(err)
+		}
+		apiRetries.WithLabelValues(e.project, scope, reason).Inc()
+		contextLogger(ctx).Debugf("Retrying %s call of %s in %s: %v", scope, e.project, wait, err)
+
+		select {
+		case <-ctx.Done():
+			return attempt + 1, err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// maxRetryWait returns the longest Retry-After waited for before a retry: the backoff of the last retry, or
+// opts.apiTimeout when it is longer.
+func (e *Exporter) maxRetryWait() time.Duration {
+	wait := e.opts.retryBackoff << e.opts.retries
+	if e.opts.apiTimeout > wait {
+		wait = e.opts.apiTimeout
+	}
+	return wait
+}
+
+// retryable tells whether a failed Google API call is worth retrying: it was throttled, failed
+// on the server or on the network, and the scrape of ctx isn't over yet.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusTooManyRequests || apiErr.Code >= 500
+	}
+	// Invalid credentials fail the same way on every try.
+	var tokenErr *oauth2.RetrieveError
+	return !errors.As(err, &tokenErr)
+}
+
+type retryHintKey struct{}
+
+// retryHint receives the Retry-After header of the failed response of a call. googleapi.Error
+// doesn't keep the headers of JSON error responses, so retryAfterTransport records it.
+type retryHint struct {
+	retryAfter string
+}
+
+// wait returns the wait asked for by the Retry-After header, 0 when there is none.
+func (h *retryHint) wait() time.Duration {
+	if seconds, err := strconv.Atoi(h.retryAfter); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(h.retryAfter); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// retryAfterTransport records the Retry-After header of failed responses in the retryHint of the request context.
+type retryAfterTransport struct {
+	base http.RoundTripper
+}
+
+func (t retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.base.RoundTrip(req)
+	if err == nil && res.StatusCode >= 400 {
+		if hint, ok := req.Context().Value(retryHintKey{}).(*retryHint); ok {
+			hint.retryAfter = res.Header.Get("Retry-After")
+		}
+	}
+	return res, err
+}
+
+// conditionalCache keeps the last Compute API responses of a project, so unchanged data is
+// revalidated with If-None-Match and their ETag instead of being downloaded again.
+type conditionalCache struct {
+	project *compute.Project
+	regions *compute.RegionList
+	mutex   sync.Mutex
+}
+
+func (c *conditionalCache) get() (*compute.Project, *compute.RegionList) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.project, c.regions
+}
+
+// set stores the responses of a scrape, nil for a failed call keeps the previous response.
+func (c *conditionalCache) set(project *compute.Project, regions *compute.RegionList) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if project != nil {
+		c.project = project
+	}
+	if regions != nil {
+		c.regions = regions
+	}
+}
+
+// etag returns the ETag of a cached response, empty when there is none.
+func etag(res *googleapi.ServerResponse) string {
+	if res == nil {
+		return ""
+	}
+	return res.Header.Get("ETag")
+}
+
+// notModified tells whether a conditional call failed because the data didn't change,
+// counting it in gcp_quota_api_not_modified_total.
+func notModified(project, scope string, err error) bool {
+	if !googleapi.IsNotModified(err) {
+		return false
+	}
+	apiNotModified.WithLabelValues(project, scope).Inc()
+	return true
+}
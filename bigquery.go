@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// bigQuerySchema is the schema of the table the quota snapshots are appended to.
+var bigQuerySchema = &bigquery.TableSchema{Fields: []*bigquery.TableFieldSchema{
+	{Name: "time", Type: "TIMESTAMP", Mode: "REQUIRED", Description: "Time the quotas were fetched."},
+	{Name: "project", Type: "STRING", Mode: "REQUIRED"},
+	{Name: "region", Type: "STRING", Description: "Region of the quota, empty for the project quotas."},
+	{Name: "metric", Type: "STRING", Mode: "REQUIRED"},
+	{Name: "category", Type: "STRING"},
+	{Name: "unit", Type: "STRING"},
+	{Name: "limit", Type: "FLOAT", Description: "Limit of the quota, negative when unlimited."},
+	{Name: "usage", Type: "FLOAT"},
+}}
+
+// bigQuerySink appends every quota snapshot of the projects to a BigQuery table, for the analysis of the
+// quota growth over more time than the Prometheus retention. A snapshot is appended once, when the quotas
+// of a project were fetched again since the last write.
+type bigQuerySink struct {
+	service   *bigquery.Service
+	project   string // project of the dataset
+	dataset   string
+	table     string
+	batchSize int // maximum number of rows of an insertAll call
+	projects  *projectSet
+	leader    *leaderElector
+
+	ready   bool                 // set once the table is known to exist
+	written map[string]time.Time // fetch time of the last snapshot appended per project
+}
+
+// newBigQuerySink returns a sink appending to project.dataset.table with the credentials, User-Agent and headers
+// of config. base is the transport of the calls, nil for http.DefaultTransport.
+func newBigQuerySink(config clientConfig, project, dataset, table string, batchSize int, base http.RoundTripper, projects *projectSet, leader *leaderElector) (*bigQuerySink, error) {
+	if project == "" || dataset == "" || table == "" {
+		return nil, fmt.Errorf("the project, dataset and table of the BigQuery sink must be set")
+	}
+	if batchSize <= 0 {
+		return nil, fmt.Errorf("invalid BigQuery batch size %d, must be positive", batchSize)
+	}
+	client, err := sinkClient(config, base)
+	if err != nil {
+		return nil, err
+	}
+	service, err := bigquery.NewService(context.Background(), option.WithHTTPClient(client))
+	if err != nil {
+		return nil, err
+	}
+	return &bigQuerySink{
+		service:   service,
+		project:   project,
+		dataset:   dataset,
+		table:     table,
+		batchSize: batchSize,
+		projects:  projects,
+		leader:    leader,
+		written:   make(map[string]time.Time),
+	}, nil
+}
+
+// run appends the new snapshots every interval until ctx is done. Only the leader writes, so the replicas
+// don't append the same rows.
+func (s *bigQuerySink) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if s.leader.leading() {
+			writeCtx, cancel := context.WithTimeout(ctx, interval)
+			s.write(writeCtx)
+			cancel()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ensureTable creates the table when it doesn't exist, partitioned by day on the fetch time.
+func (s *bigQuerySink) ensureTable(ctx context.Context) error {
+	_, err := s.service.Tables.Get(s.project, s.dataset, s.table).Context(ctx).Do()
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusNotFound {
+		return err
+	}
+	table := &bigquery.Table{
+		TableReference:   &bigquery.TableReference{ProjectId: s.project, DatasetId: s.dataset, TableId: s.table},
+		Schema:           bigQuerySchema,
+		TimePartitioning: &bigquery.TimePartitioning{Type: "DAY", Field: "time"},
+	}
+	if _, err := s.service.Tables.Insert(s.project, s.dataset, table).Context(ctx).Do(); err != nil {
+		return err
+	}
+	log.Infof("Created the BigQuery table %s.%s.%s", s.project, s.dataset, s.table)
+	return nil
+}
+
+// bigQueryRow is a row to append along with the project it belongs to.
+type bigQueryRow struct {
+	project string
+	row     *bigquery.TableDataInsertAllRequestRows
+}
+
+// write scrapes the projects and appends their snapshots fetched since the last write. The rows of a project
+// whose batch failed are appended again by the next write, their insert IDs let BigQuery drop the duplicates.
+func (s *bigQuerySink) write(ctx context.Context) {
+	if !s.ready {
+		if err := s.ensureTable(ctx); err != nil {
+			pushFailures.WithLabelValues("bigquery").Inc()
+			log.Errorf("Couldn't get the BigQuery table %s.%s.%s: %v", s.project, s.dataset, s.table, err)
+			return
+		}
+		s.ready = true
+	}
+
+	// Collecting the projects fetches their quotas when they are scraped on demand.
+	if _, err := gatherProjects(ctx, s.projects); err != nil {
+		log.Warnf("Couldn't gather all metrics for BigQuery: %v", err)
+	}
+
+	_, exporters := s.projects.get()
+	names := make([]string, 0, len(exporters))
+	for project := range exporters {
+		names = append(names, project)
+	}
+	sort.Strings(names)
+
+	written := make(map[string]time.Time, len(names))
+	fetchedAt := make(map[string]time.Time)
+	var rows []bigQueryRow
+	for _, project := range names {
+		written[project] = s.written[project]
+		quotas, fetched, ok := exporters[project].latestQuotas()
+		if !ok || !fetched.After(s.written[project]) {
+			continue
+		}
+		fetchedAt[project] = fetched
+		for _, q := range quotas {
+			rows = append(rows, bigQueryRow{project: project, row: &bigquery.TableDataInsertAllRequestRows{
+				InsertId: project + "/" + q.Region + "/" + q.Metric + "/" + strconv.FormatInt(fetched.UnixNano(), 10),
+				Json: map[string]bigquery.JsonValue{
+					"time":     fetched.UTC().Format(time.RFC3339Nano),
+					"project":  project,
+					"region":   q.Region,
+					"metric":   q.Metric,
+					"category": q.Category,
+					"unit":     q.Unit,
+					"limit":    q.Limit,
+					"usage":    q.Usage,
+				},
+			}})
+		}
+	}
+
+	failed := make(map[string]bool)
+	for start := 0; start < len(rows); start += s.batchSize {
+		end := start + s.batchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		batch := rows[start:end]
+		request := &bigquery.TableDataInsertAllRequest{Rows: make([]*bigquery.TableDataInsertAllRequestRows, len(batch))}
+		for i, r := range batch {
+			request.Rows[i] = r.row
+		}
+		resp, err := s.service.Tabledata.InsertAll(s.project, s.dataset, s.table, request).Context(ctx).Do()
+		if err == nil && len(resp.InsertErrors) > 0 {
+			err = fmt.Errorf("%d rows rejected, first: %v", len(resp.InsertErrors), insertError(resp.InsertErrors[0]))
+		}
+		if err != nil {
+			pushFailures.WithLabelValues("bigquery").Inc()
+			log.Errorf("Couldn't append %d rows to BigQuery: %v", len(batch), err)
+			for _, r := range batch {
+				failed[r.project] = true
+			}
+			continue
+		}
+		pushSamples.WithLabelValues("bigquery").Add(float64(len(batch)))
+	}
+
+	for project, fetched := range fetchedAt {
+		if !failed[project] {
+			written[project] = fetched
+		}
+	}
+	// The projects removed from the config are forgotten.
+	s.written = written
+}
+
+// insertError returns the reason of a row rejected by insertAll.
+func insertError(e *bigquery.TableDataInsertAllResponseInsertErrors) string {
+	if len(e.Errors) == 0 {
+		return "unknown"
+	}
+	return e.Errors[0].Reason + ": " + e.Errors[0].Message
+}
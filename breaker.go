@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// circuitBreaker skips the scrapes of a project after consecutive failures, e.g. revoked
+// credentials, so a broken project doesn't take up the scrape budget and flood the logs.
+// Once the cool-down is over a single scrape is let through, which closes the circuit on
+// success and opens it again on failure.
+type circuitBreaker struct {
+	project   string
+	failures  int // consecutive failures opening the circuit, 0 disables the breaker
+	cooldown  time.Duration
+	failed    int // consecutive failures so far
+	openUntil time.Time
+	mutex     sync.Mutex
+}
+
+// check returns an error while the circuit is open and the scrape must be skipped.
+func (b *circuitBreaker) check() error {
+	if b.failures <= 0 {
+		return nil
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if time.Now().Before(b.openUntil) {
+		return fmt.Errorf("circuit open until %s after %d consecutive failures", b.openUntil.Format(time.RFC3339), b.failed)
+	}
+	return nil
+}
+
+// record counts the outcome of a scrape let through by check.
+func (b *circuitBreaker) record(ok bool) {
+	if b.failures <= 0 {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if ok {
+		if b.failed >= b.failures {
+			log.Infof("Closing circuit of %s, the scrape succeeded again", b.project)
+		}
+		b.failed = 0
+		return
+	}
+	b.failed++
+	if b.failed >= b.failures {
+		b.openUntil = time.Now().Add(b.cooldown)
+		if b.failed == b.failures {
+			log.Warnf("Opening circuit of %s after %d consecutive failures, skipping its scrapes for %s", b.project, b.failed, b.cooldown)
+		}
+	}
+}
+
+// open tells whether the scrapes of the project are being skipped.
+func (b *circuitBreaker) open() bool {
+	if b.failures <= 0 {
+		return false
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return time.Now().Before(b.openUntil)
+}
+
+// slowRegionFetches is the number of fetches in a row a region must be slower than the threshold to be skipped.
+const slowRegionFetches = 3
+
+// slowRegions skips the regions of a project whose regional endpoint was slower than the threshold on
+// slowRegionFetches fetches in a row, so a misbehaving endpoint doesn't take up the scrape budget of the
+// project. Once the cool-down is over the region is fetched again, and skipped again while still slow.
+type slowRegions struct {
+	project   string
+	threshold time.Duration // fetch duration over which a region is slow, 0 disables the skipping
+	cooldown  time.Duration
+	slow      map[string]int       // slow fetches in a row by region
+	skipUntil map[string]time.Time // end of the cool-down by region
+	mutex     sync.Mutex
+}
+
+// skipped tells whether the fetches of region are being skipped.
+func (s *slowRegions) skipped(region string) bool {
+	if s.threshold <= 0 {
+		return false
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return time.Now().Before(s.skipUntil[region])
+}
+
+// record counts the duration of a fetch of region let through by skipped.
+func (s *slowRegions) record(region string, duration time.Duration) {
+	if s.threshold <= 0 {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.slow == nil {
+		s.slow = make(map[string]int)
+		s.skipUntil = make(map[string]time.Time)
+	}
+	if duration <= s.threshold {
+		delete(s.slow, region)
+		delete(s.skipUntil, region)
+		return
+	}
+	s.slow[region]++
+	if s.slow[region] >= slowRegionFetches {
+		s.skipUntil[region] = time.Now().Add(s.cooldown)
+		log.Warnf("Region %s of %s took %s, over %s on %d fetches in a row, skipping it for %s", region, s.project, duration.Round(time.Millisecond), s.threshold, s.slow[region], s.cooldown)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Protocols of the bridge to legacy monitoring systems.
+const (
+	bridgeGraphite = "graphite"
+	bridgeStatsD   = "statsd"
+)
+
+// statsdPacketSize keeps the StatsD datagrams below the usual MTU.
+const statsdPacketSize = 1400
+
+// bridge emits the metrics to Graphite over its plaintext protocol or to StatsD as gauges. The labels become
+// path components: gcp_quota_limit{project="p",region="r"} is <prefix>.gcp_quota_limit.project.p.region.r, and
+// labels with an empty value are left out.
+type bridge struct {
+	address  string
+	protocol string
+	prefix   string
+	timeout  time.Duration
+	projects *projectSet
+	leader   *leaderElector
+}
+
+func newBridge(address, protocol, prefix string, timeout time.Duration, projects *projectSet, leader *leaderElector) (*bridge, error) {
+	if protocol != bridgeGraphite && protocol != bridgeStatsD {
+		return nil, fmt.Errorf("unknown bridge protocol %q, must be %s or %s", protocol, bridgeGraphite, bridgeStatsD)
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, fmt.Errorf("invalid bridge address %q: %v", address, err)
+	}
+	return &bridge{address: address, protocol: protocol, prefix: strings.TrimSuffix(prefix, "."), timeout: timeout, projects: projects, leader: leader}, nil
+}
+
+// run flushes the metrics every interval until ctx is done. Only the leader flushes, so the replicas
+// don't send the same metrics.
+func (b *bridge) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if b.leader.leading() {
+			b.flush(ctx, interval)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// flush collects the metrics of all projects within interval and sends them.
+func (b *bridge) flush(ctx context.Context, interval time.Duration) {
+	collectCtx, cancel := context.WithTimeout(ctx, interval)
+	families, err := gatherProjects(collectCtx, b.projects)
+	cancel()
+	if err != nil {
+		// The families gathered without an error are sent anyway.
+		log.Warnf("Couldn't gather all metrics for the %s bridge: %v", b.protocol, err)
+	}
+	series := flattenFamilies(families, time.Now().UnixNano()/int64(time.Millisecond))
+
+	if b.protocol == bridgeStatsD {
+		err = b.sendStatsD(series)
+	} else {
+		err = b.sendGraphite(series)
+	}
+	if err != nil {
+		pushFailures.WithLabelValues(b.protocol).Inc()
+		log.Errorf("Couldn't send the metrics to %s: %v", b.address, err)
+		return
+	}
+	pushSamples.WithLabelValues(b.protocol).Add(float64(len(series)))
+}
+
+// sendGraphite writes "<path> <value> <timestamp>" lines over a new TCP connection.
+func (b *bridge) sendGraphite(series []flatSeries) error {
+	conn, err := net.DialTimeout("tcp", b.address, b.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(b.timeout))
+	var buf bytes.Buffer
+	for _, s := range series {
+		fmt.Fprintf(&buf, "%s %s %d\n", b.path(s), strconv.FormatFloat(s.value, 'g', -1, 64), s.timestamp/1000)
+	}
+	_, err = conn.Write(buf.Bytes())
+	return err
+}
+
+// sendStatsD sends "<path>:<value>|g" lines in UDP datagrams. Every sample is a gauge, as the counters
+// are cumulative and StatsD counters would add them up.
+func (b *bridge) sendStatsD(series []flatSeries) error {
+	conn, err := net.DialTimeout("udp", b.address, b.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var packet bytes.Buffer
+	for _, s := range series {
+		line := fmt.Sprintf("%s:%s|g\n", b.path(s), strconv.FormatFloat(s.value, 'g', -1, 64))
+		if packet.Len() > 0 && packet.Len()+len(line) > statsdPacketSize {
+			if _, err := conn.Write(packet.Bytes()); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		_, err = conn.Write(packet.Bytes())
+	}
+	return err
+}
+
+// path returns the dotted path of a series.
+func (b *bridge) path(s flatSeries) string {
+	var path strings.Builder
+	if b.prefix != "" {
+		path.WriteString(b.prefix)
+		path.WriteByte('.')
+	}
+	for _, l := range s.labels {
+		if l.GetName() == "__name__" {
+			path.WriteString(bridgeComponent(l.GetValue()))
+		}
+	}
+	for _, l := range s.labels {
+		if l.GetName() == "__name__" || l.GetValue() == "" {
+			continue
+		}
+		path.WriteByte('.')
+		path.WriteString(bridgeComponent(l.GetName()))
+		path.WriteByte('.')
+		path.WriteString(bridgeComponent(l.GetValue()))
+	}
+	return path.String()
+}
+
+// bridgeComponent replaces the characters with a meaning in Graphite and StatsD paths by underscores.
+func bridgeComponent(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		case r == '+':
+			// le="+Inf"
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// scrapeOrder makes the projects of a scrape take the scrape slots in a fixed order, so when the
+// scrape timeout runs out it is the projects at the end of the order which are cut off. The order
+// is rotated on every scrape, so it isn't always the same projects.
+type scrapeOrder struct {
+	passed []chan struct{} // closed once the project took its slot or doesn't need one
+	once   []sync.Once
+}
+
+func newScrapeOrder(n int) *scrapeOrder {
+	o := &scrapeOrder{passed: make([]chan struct{}, n), once: make([]sync.Once, n)}
+	for i := range o.passed {
+		o.passed[i] = make(chan struct{})
+	}
+	return o
+}
+
+// scrapeTurn is the place of a project in the scrapeOrder of a scrape.
+type scrapeTurn struct {
+	order *scrapeOrder
+	index int
+}
+
+type scrapeTurnKey struct{}
+
+// withScrapeTurn returns a copy of ctx carrying the turn of a project.
+func withScrapeTurn(ctx context.Context, turn scrapeTurn) context.Context {
+	return context.WithValue(ctx, scrapeTurnKey{}, turn)
+}
+
+// waitTurn blocks until the projects before the one of ctx took their slot, or ctx is done.
+// It returns at once when ctx carries no turn.
+func waitTurn(ctx context.Context) {
+	turn, ok := ctx.Value(scrapeTurnKey{}).(scrapeTurn)
+	if !ok {
+		return
+	}
+	for _, passed := range turn.order.passed[:turn.index] {
+		select {
+		case <-passed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// passTurn lets the projects after the one of ctx go ahead. It may be called several times.
+func passTurn(ctx context.Context) {
+	if turn, ok := ctx.Value(scrapeTurnKey{}).(scrapeTurn); ok {
+		turn.pass()
+	}
+}
+
+func (t scrapeTurn) pass() {
+	t.order.once[t.index].Do(func() { close(t.order.passed[t.index]) })
+}
+
+// rotateExporters returns the exporters sorted by project and rotated by round.
+func rotateExporters(exporters []*Exporter, round uint32) []*Exporter {
+	sorted := make([]*Exporter, len(exporters))
+	copy(sorted, exporters)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].project < sorted[j].project })
+	if len(sorted) == 0 {
+		return sorted
+	}
+	shift := int(round % uint32(len(sorted)))
+	return append(sorted[shift:], sorted[:shift]...)
+}
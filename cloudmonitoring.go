@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/monitoring/v3"
+	"google.golang.org/api/option"
+)
+
+// cloudMonitoringBatch is the maximum number of time series of a timeSeries.create call.
+const cloudMonitoringBatch = 200
+
+// cloudMonitoringSink writes the quota limits and usages as custom metrics to Cloud Monitoring, for
+// teams alerting with the GCP tooling. A quota is written as the gauges <prefix>/limit and <prefix>/usage
+// with the project, region, metric, category and unit labels on the global resource of the target project.
+type cloudMonitoringSink struct {
+	service  *monitoring.Service
+	project  string // project the series are written to, empty for the project of the quota
+	prefix   string
+	projects *projectSet
+	leader   *leaderElector
+}
+
+// newCloudMonitoringSink returns a sink writing with the credentials, User-Agent and headers of config.
+// base is the transport of the calls, nil for http.DefaultTransport.
+func newCloudMonitoringSink(config clientConfig, project, prefix string, base http.RoundTripper, projects *projectSet, leader *leaderElector) (*cloudMonitoringSink, error) {
+	client, err := sinkClient(config, base)
+	if err != nil {
+		return nil, err
+	}
+	service, err := monitoring.NewService(context.Background(), option.WithHTTPClient(client))
+	if err != nil {
+		return nil, err
+	}
+	return &cloudMonitoringSink{service: service, project: project, prefix: prefix, projects: projects, leader: leader}, nil
+}
+
+// run writes the quotas every interval until ctx is done. Only the leader writes, so the replicas
+// don't write the same points.
+func (s *cloudMonitoringSink) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if s.leader.leading() {
+			writeCtx, cancel := context.WithTimeout(ctx, interval)
+			s.write(writeCtx)
+			cancel()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// write scrapes the projects and writes their last fetched quotas. The points are stamped with the time
+// of the write, as Cloud Monitoring rejects a point not newer than the previous one of its series.
+func (s *cloudMonitoringSink) write(ctx context.Context) {
+	// Collecting the projects fetches their quotas when they are scraped on demand.
+	if _, err := gatherProjects(ctx, s.projects); err != nil {
+		log.Warnf("Couldn't gather all metrics for Cloud Monitoring: %v", err)
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+
+	series := map[string][]*monitoring.TimeSeries{}
+	_, exporters := s.projects.get()
+	for project, exporter := range exporters {
+		quotas, _, ok := exporter.latestQuotas()
+		if !ok {
+			continue
+		}
+		target := s.project
+		if target == "" {
+			target = project
+		}
+		for _, q := range quotas {
+			region := q.Region
+			if region == "" {
+				// Cloud Monitoring drops labels with an empty value.
+				region = "global"
+			}
+			labels := map[string]string{"project": project, "region": region, "metric": q.Metric, "category": q.Category, "unit": q.Unit}
+			for _, m := range []struct {
+				name  string
+				value float64
+			}{{"limit", q.Limit}, {"usage", q.Usage}} {
+				value := m.value
+				series[target] = append(series[target], &monitoring.TimeSeries{
+					Metric:     &monitoring.Metric{Type: s.prefix + "/" + m.name, Labels: labels},
+					Resource:   &monitoring.MonitoredResource{Type: "global", Labels: map[string]string{"project_id": target}},
+					MetricKind: "GAUGE",
+					ValueType:  "DOUBLE",
+					Points: []*monitoring.Point{{
+						Interval: &monitoring.TimeInterval{EndTime: now},
+						Value:    &monitoring.TypedValue{DoubleValue: &value},
+					}},
+				})
+			}
+		}
+	}
+
+	targets := make([]string, 0, len(series))
+	for target := range series {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	for _, target := range targets {
+		list := series[target]
+		for start := 0; start < len(list); start += cloudMonitoringBatch {
+			end := start + cloudMonitoringBatch
+			if end > len(list) {
+				end = len(list)
+			}
+			request := &monitoring.CreateTimeSeriesRequest{TimeSeries: list[start:end]}
+			if _, err := s.service.Projects.TimeSeries.Create("projects/"+target, request).Context(ctx).Do(); err != nil {
+				pushFailures.WithLabelValues("cloud_monitoring").Inc()
+				log.Errorf("Couldn't write %d time series to Cloud Monitoring in %s: %v", end-start, target, err)
+				continue
+			}
+			pushSamples.WithLabelValues("cloud_monitoring").Add(float64(end - start))
+		}
+	}
+}
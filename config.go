@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+type gcpQuota struct {
+	Project            string             `json:"Project"`
+	Regions            []string           `json:"Regions"`
+	Credentials        string             `json:"Credentials"`
+	Thresholds         map[string]float64 `json:"Thresholds" yaml:"thresholds"`
+	Profile            string             `json:"Profile" yaml:"profile"`
+	APIEndpoint        string             `json:"APIEndpoint" yaml:"api_endpoint"`
+	ProxyURL           string             `json:"ProxyURL" yaml:"proxy_url"`
+	RegionalEndpoint   string             `json:"RegionalEndpoint" yaml:"regional_endpoint"`
+	SlackChannel       string             `json:"SlackChannel" yaml:"slack_channel"`
+	CriticalThresholds map[string]float64 `json:"CriticalThresholds" yaml:"critical_thresholds"`
+	PagerDutyTeam      string             `json:"PagerDutyTeam" yaml:"pagerduty_team"`
+}
+
+// configError is an invalid project entry of the config, exported by gcp_quota_config_project_error.
+type configError struct {
+	project string
+	reason  string
+}
+
+// loadConfig reads the project list from path. Invalid entries are left out of
+// the returned projects and reported as configErrors instead.
+func loadConfig(path string) ([]gcpQuota, []configError, error) {
+	config, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var projectList []gcpQuota
+	if err := yaml.Unmarshal(config, &projectList); err != nil {
+		return nil, nil, err
+	}
+
+	var projects []gcpQuota
+	var errs []configError
+	seen := make(map[string]bool)
+	for _, project := range projectList {
+		if project.Project == "" {
+			log.Errorf("Project not specified in %s", path)
+			errs = append(errs, configError{reason: "missing_project"})
+			continue
+		}
+		if project.Credentials == "" {
+			log.Errorf("Credential not specified for %s", project.Project)
+			errs = append(errs, configError{project: project.Project, reason: "missing_credentials"})
+			continue
+		}
+
+		if err := checkProfile(project.Profile); err != nil {
+			log.Errorf("Invalid profile of %s: %v", project.Project, err)
+			errs = append(errs, configError{project: project.Project, reason: "invalid_profile"})
+			continue
+		}
+
+		if err := checkAPIEndpoint(project.APIEndpoint); err != nil {
+			log.Errorf("Invalid API endpoint of %s: %v", project.Project, err)
+			errs = append(errs, configError{project: project.Project, reason: "invalid_api_endpoint"})
+			continue
+		}
+
+		if err := checkRegionalEndpoint(project.RegionalEndpoint); err != nil || (project.RegionalEndpoint != "" && len(project.Regions) == 0) {
+			if err == nil {
+				err = fmt.Errorf("the regions must be listed, they can't be discovered at a regional endpoint")
+			}
+			log.Errorf("Invalid regional endpoint of %s: %v", project.Project, err)
+			errs = append(errs, configError{project: project.Project, reason: "invalid_regional_endpoint"})
+			continue
+		}
+
+		if err := checkProxy(project.ProxyURL); err != nil {
+			log.Errorf("Invalid proxy of %s: %v", project.Project, err)
+			errs = append(errs, configError{project: project.Project, reason: "invalid_proxy_url"})
+			continue
+		}
+
+		for metric, threshold := range project.Thresholds {
+			if threshold <= 0 {
+				log.Errorf("Invalid threshold %v for %s in %s", threshold, metric, project.Project)
+				delete(project.Thresholds, metric)
+				errs = append(errs, configError{project: project.Project, reason: "invalid_threshold"})
+			}
+		}
+		for metric, threshold := range project.CriticalThresholds {
+			if threshold <= 0 {
+				log.Errorf("Invalid critical threshold %v for %s in %s", threshold, metric, project.Project)
+				delete(project.CriticalThresholds, metric)
+				errs = append(errs, configError{project: project.Project, reason: "invalid_threshold"})
+			}
+		}
+
+		if _, err := os.Stat(project.Credentials); err != nil {
+			log.Errorf("Credential file [%s] not found for %s", project.Credentials, project.Project)
+			errs = append(errs, configError{project: project.Project, reason: "credentials_not_found"})
+			continue
+		}
+
+		if seen[project.Project] {
+			log.Errorf("Duplicate project [%v] in %v.", project.Project, path)
+			errs = append(errs, configError{project: project.Project, reason: "duplicate"})
+			continue
+		}
+		seen[project.Project] = true
+		projects = append(projects, project)
+	}
+	return projects, errs, nil
+}
+
+// checkProjectLimit fails when there are more than max projects, 0 means no limit.
+func checkProjectLimit(projects []gcpQuota, max int) error {
+	if max > 0 && len(projects) > max {
+		return fmt.Errorf("%d projects exceed the limit of %d projects per replica, shard them across replicas with -shard.total", len(projects), max)
+	}
+	return nil
+}
+
+// projectSet holds the loaded projects along with their exporters. Both are
+// replaced together when the config is reloaded.
+type projectSet struct {
+	projects   []gcpQuota
+	exporters  map[string]*Exporter
+	metricInfo bool                // export gcp_quota_metric_info
+	info       *metricInfoExporter // exports gcp_quota_metric_info with the first project, nil when disabled or without projects
+	mutex      sync.RWMutex
+}
+
+func (s *projectSet) get() ([]gcpQuota, map[string]*Exporter) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.projects, s.exporters
+}
+
+func (s *projectSet) set(projects []gcpQuota, exporters map[string]*Exporter) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.projects = projects
+	s.exporters = exporters
+	// The display names are fetched again when the first project changes, as its exporter may be gone
+	// or may have failed to load its credentials.
+	if !s.metricInfo || len(projects) == 0 {
+		s.info = nil
+	} else if exporter := exporters[projects[0].Project]; s.info == nil || s.info.exporter != exporter {
+		s.info = NewMetricInfoExporter(exporter)
+	}
+}
+
+// metricInfoExporter returns the exporter of gcp_quota_metric_info, nil when it is disabled.
+func (s *projectSet) metricInfoExporter() *metricInfoExporter {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.info
+}
+
+// configExporter exports the invalid entries of the config.
+type configExporter struct {
+	errs  []configError
+	mutex sync.RWMutex
+}
+
+func (e *configExporter) Describe(ch chan<- *prometheus.Desc) {}
+
+func (e *configExporter) set(errs []configError) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.errs = errs
+}
+
+func (e *configExporter) Collect(ch chan<- prometheus.Metric) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	counts := make(map[configError]int)
+	for _, err := range e.errs {
+		counts[err]++
+	}
+	for err, count := range counts {
+		ch <- prometheus.MustNewConstMetric(configProjectErrorDesc, prometheus.GaugeValue, float64(count), err.project, err.reason)
+	}
+}
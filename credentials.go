@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/option"
+	"google.golang.org/api/serviceusage/v1beta1"
+	htransport "google.golang.org/api/transport/http"
+)
+
+// apiClients are the Google API clients of a credential.
+type apiClients struct {
+	compute      *compute.Service
+	rm           *cloudresourcemanager.Service
+	serviceusage *serviceusage.APIService
+
+	client   *http.Client                // shared by the regional Compute clients
+	regional map[string]*compute.Service // Compute clients of the regional endpoints by base URL
+	mutex    sync.Mutex                  // protects regional
+}
+
+// clientConfig are the settings of the Google API clients of a project, the projects with the
+// same settings share their clients.
+type clientConfig struct {
+	credentials string // credentials file, empty for the Application Default Credentials
+	endpoint    string // base URL of the Google APIs, empty for the default endpoints
+	proxy       string // proxy of the calls, empty for the proxy of the shared transport
+	userAgent   string // put in front of the User-Agent of the calls
+	headers     string // comma separated name=value headers of the calls
+}
+
+// clientPool shares the clients of a credential between all exporters using it, so the
+// projects of a service account share its token and connections instead of refreshing
+// a token of their own.
+type clientPool struct {
+	clients map[clientConfig]*pooledClients
+	mutex   sync.Mutex
+}
+
+// pooledClients are the clients of a credential, done is closed once they are created.
+type pooledClients struct {
+	done    chan struct{}
+	clients *apiClients
+	err     error
+}
+
+func newClientPool() *clientPool {
+	return &clientPool{clients: make(map[clientConfig]*pooledClients)}
+}
+
+// get returns the clients of config, creating them on first use. The clients of different configs
+// are created concurrently, a failure is retried by the next call. A nil pool creates new clients on every call.
+func (p *clientPool) get(config clientConfig, base http.RoundTripper) (*apiClients, error) {
+	if p == nil {
+		return newAPIClients(config, base)
+	}
+	p.mutex.Lock()
+	pooled, ok := p.clients[config]
+	if !ok {
+		pooled = &pooledClients{done: make(chan struct{})}
+		p.clients[config] = pooled
+	}
+	p.mutex.Unlock()
+
+	if !ok {
+		pooled.clients, pooled.err = newAPIClients(config, base)
+		if pooled.err != nil {
+			p.mutex.Lock()
+			delete(p.clients, config)
+			p.mutex.Unlock()
+		}
+		close(pooled.done)
+	}
+	<-pooled.done
+	return pooled.clients, pooled.err
+}
+
+// size returns the number of client configs with clients.
+func (p *clientPool) size() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.clients)
+}
+
+// newAPIClients creates the clients of config. base is the transport of the calls, nil for http.DefaultTransport.
+func newAPIClients(config clientConfig, base http.RoundTripper) (*apiClients, error) {
+	ctx := context.Background()
+	creds, err := findCredentials(ctx, config.credentials)
+	if err != nil {
+		return nil, err
+	}
+	// The JSON is kept for the quota project of the credentials.
+	counted := &google.Credentials{
+		ProjectID:   creds.ProjectID,
+		TokenSource: &countingTokenSource{base: creds.TokenSource, credentials: config.credentials},
+		JSON:        creds.JSON,
+	}
+
+	// The transport is built here instead of by the clients, so the headers of failed responses can be seen.
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	base, err = withHeaders(withProxy(base, config.proxy), config.userAgent, config.headers)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := htransport.NewTransport(ctx, retryAfterTransport{base: base}, option.WithCredentials(counted))
+	if err != nil {
+		return nil, err
+	}
+	httpClient := &http.Client{Transport: transport}
+	client := option.WithHTTPClient(httpClient)
+	computeOptions, rmOptions, usageOptions := []option.ClientOption{client}, []option.ClientOption{client}, []option.ClientOption{client}
+	if config.endpoint != "" {
+		computeOptions = append(computeOptions, option.WithEndpoint(serviceEndpoint(config.endpoint, "compute")+"/compute/v1/"))
+		rmOptions = append(rmOptions, option.WithEndpoint(serviceEndpoint(config.endpoint, "cloudresourcemanager")+"/"))
+		usageOptions = append(usageOptions, option.WithEndpoint(serviceEndpoint(config.endpoint, "serviceusage")+"/"))
+	}
+	computeService, err := compute.NewService(ctx, computeOptions...)
+	if err != nil {
+		return nil, err
+	}
+	rmService, err := cloudresourcemanager.NewService(ctx, rmOptions...)
+	if err != nil {
+		return nil, err
+	}
+	usageService, err := serviceusage.NewService(ctx, usageOptions...)
+	if err != nil {
+		return nil, err
+	}
+	// The Google APIs only compress the responses of clients with gzip in their User-Agent,
+	// the transport asks for gzip and decompresses the responses.
+	computeService.UserAgent = "gzip"
+	rmService.UserAgent = "gzip"
+	usageService.UserAgent = "gzip"
+	return &apiClients{compute: computeService, rm: rmService, serviceusage: usageService, client: httpClient, regional: make(map[string]*compute.Service)}, nil
+}
+
+// regionalCompute returns the Compute client of region at the regional endpoint, in which {region} is replaced
+// by the region, e.g. https://compute.{region}.rep.googleapis.com. The clients are created on first use.
+func (c *apiClients) regionalCompute(endpoint, region string) (*compute.Service, error) {
+	base := serviceEndpoint(strings.ReplaceAll(endpoint, "{region}", region), "compute") + "/compute/v1/"
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if service, ok := c.regional[base]; ok {
+		return service, nil
+	}
+	service, err := compute.NewService(context.Background(), option.WithHTTPClient(c.client), option.WithEndpoint(base))
+	if err != nil {
+		return nil, err
+	}
+	service.UserAgent = "gzip"
+	c.regional[base] = service
+	return service, nil
+}
+
+// serviceEndpoint returns the base URL of a Google API at endpoint: {service} is replaced by the name of the API,
+// e.g. https://{service}-myendpoint.p.googleapis.com for a Private Service Connect endpoint, else all APIs share it.
+func serviceEndpoint(endpoint, service string) string {
+	return strings.TrimSuffix(strings.ReplaceAll(endpoint, "{service}", service), "/")
+}
+
+// sinkClient returns the HTTP client of the Google API calls of a sink, with the credentials, User-Agent and
+// headers of config. base is the transport of the calls, nil for http.DefaultTransport.
+func sinkClient(config clientConfig, base http.RoundTripper) (*http.Client, error) {
+	ctx := context.Background()
+	creds, err := findCredentials(ctx, config.credentials)
+	if err != nil {
+		return nil, err
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	base, err = withHeaders(base, config.userAgent, config.headers)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := htransport.NewTransport(ctx, base, option.WithCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// checkAPIEndpoint fails for an API endpoint which isn't an http or https URL without a path, empty is valid.
+func checkAPIEndpoint(endpoint string) error {
+	if endpoint == "" {
+		return nil
+	}
+	u, err := url.Parse(serviceEndpoint(endpoint, "service"))
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("API endpoint %q must be an http or https URL", endpoint)
+	}
+	if u.Path != "" {
+		return fmt.Errorf("API endpoint %q must not have a path, the paths of the APIs are added to it", endpoint)
+	}
+	return nil
+}
+
+// checkRegionalEndpoint checks a regional endpoint, an API endpoint with {region} in it.
+func checkRegionalEndpoint(endpoint string) error {
+	if endpoint == "" {
+		return nil
+	}
+	if !strings.Contains(endpoint, "{region}") {
+		return fmt.Errorf("regional endpoint %q must contain {region}", endpoint)
+	}
+	return checkAPIEndpoint(strings.ReplaceAll(endpoint, "{region}", "region"))
+}
+
+// findCredentials loads the credentials file, the Application Default Credentials when it is empty.
+func findCredentials(ctx context.Context, credentials string) (*google.Credentials, error) {
+	if credentials == "" {
+		return google.FindDefaultCredentials(ctx, compute.CloudPlatformScope)
+	}
+	content, err := ioutil.ReadFile(credentials)
+	if err != nil {
+		return nil, err
+	}
+	return google.CredentialsFromJSON(ctx, content, compute.CloudPlatformScope)
+}
+
+// countingTokenSource counts the token refreshes of a credential. base reuses its token until
+// it expires, so every new access token is a refresh.
+type countingTokenSource struct {
+	base        oauth2.TokenSource
+	credentials string
+	last        string
+	mutex       sync.Mutex
+}
+
+func (s *countingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.base.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if token.AccessToken != s.last {
+		s.last = token.AccessToken
+		tokenRefreshes.WithLabelValues(s.credentials).Inc()
+	}
+	return token, nil
+}
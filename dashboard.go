@@ -0,0 +1,151 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// dashboardRow is a quota line of the dashboard.
+type dashboardRow struct {
+	Project string
+	Fetched time.Time
+	quotaValue
+	Ratio float64 // usage/limit, -1 when the quota is unlimited or zero
+}
+
+// Percent returns the utilization bar width.
+func (r dashboardRow) Percent() float64 {
+	if r.Ratio < 0 {
+		return 0
+	}
+	if r.Ratio > 1 {
+		return 100
+	}
+	return r.Ratio * 100
+}
+
+// Color returns the utilization bar colour.
+func (r dashboardRow) Color() string {
+	switch {
+	case r.Ratio >= 0.9:
+		return "#d9534f"
+	case r.Ratio >= 0.7:
+		return "#f0ad4e"
+	}
+	return "#5cb85c"
+}
+
+// dashboardSorts orders the rows by the sort query parameter, utilization is the default.
+var dashboardSorts = map[string]func(a, b dashboardRow) bool{
+	"utilization": func(a, b dashboardRow) bool { return a.Ratio > b.Ratio },
+	"project":     func(a, b dashboardRow) bool { return a.Project < b.Project },
+	"region":      func(a, b dashboardRow) bool { return a.Region < b.Region },
+	"metric":      func(a, b dashboardRow) bool { return a.Metric < b.Metric },
+	"category":    func(a, b dashboardRow) bool { return a.Category < b.Category },
+	"usage":       func(a, b dashboardRow) bool { return a.Usage > b.Usage },
+	"limit":       func(a, b dashboardRow) bool { return a.Limit > b.Limit },
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<html>
+<head><title>GCP Quota Dashboard</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; }
+td.num { text-align: right; }
+.bar { width: 200px; background: #eee; }
+.bar div { height: 12px; }
+</style>
+</head>
+<body>
+<h1>GCP Quota Dashboard</h1>
+<p><a href="/">Home</a></p>
+<form method="get">
+Project <select name="project"><option value="">all</option>{{range .Projects}}<option{{if eq . $.Project}} selected{{end}}>{{.}}</option>{{end}}</select>
+Region <select name="region"><option value="">all</option>{{range .Regions}}<option{{if eq . $.Region}} selected{{end}}>{{.}}</option>{{end}}</select>
+<input type="hidden" name="sort" value="{{.Sort}}">
+<input type="submit" value="Filter">
+</form>
+<table>
+<tr>{{range .Columns}}<th><a href="?project={{$.Project}}&region={{$.Region}}&sort={{.}}">{{.}}</a></th>{{end}}<th>fetched</th></tr>
+{{range .Rows}}<tr>
+<td>{{.Project}}</td><td>{{.Region}}</td><td>{{.Metric}}</td><td>{{.Category}}</td>
+<td class="num">{{.Usage}}</td><td class="num">{{if lt .Limit 0.0}}unlimited{{else}}{{.Limit}}{{end}}</td>
+<td><div class="bar"><div style="width: {{printf "%.1f" .Percent}}%; background: {{.Color}}"></div></div>{{if ge .Ratio 0.0}}{{printf "%.1f" .Percent}}%{{end}}</td>
+<td>{{.Fetched.Format "15:04:05"}}</td>
+</tr>{{else}}<tr><td colspan="8">no quota data yet</td></tr>{{end}}
+</table>
+</body>
+</html>
+`))
+
+// dashboardHandler serves an HTML table of the quotas of the last successful scrapes with
+// their utilization, filtered by the project and region query parameters.
+func dashboardHandler(projects *projectSet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := struct {
+			Project, Region, Sort string
+			Projects, Regions     []string
+			Columns               []string
+			Rows                  []dashboardRow
+		}{
+			Project: r.URL.Query().Get("project"),
+			Region:  r.URL.Query().Get("region"),
+			Sort:    r.URL.Query().Get("sort"),
+			Columns: []string{"project", "region", "metric", "category", "usage", "limit", "utilization"},
+		}
+		less, ok := dashboardSorts[data.Sort]
+		if !ok {
+			data.Sort = "utilization"
+			less = dashboardSorts[data.Sort]
+		}
+
+		regions := make(map[string]bool)
+		_, exporters := projects.get()
+		for name, exporter := range exporters {
+			data.Projects = append(data.Projects, name)
+			quotas, fetched, ok := exporter.latestQuotas()
+			if !ok {
+				continue
+			}
+			for _, quota := range quotas {
+				regions[quota.Region] = true
+				if (data.Project != "" && name != data.Project) || (data.Region != "" && quota.Region != data.Region) {
+					continue
+				}
+				row := dashboardRow{Project: name, Fetched: fetched, quotaValue: quota, Ratio: -1}
+				if quota.Limit > 0 {
+					row.Ratio = quota.Usage / quota.Limit
+				}
+				data.Rows = append(data.Rows, row)
+			}
+		}
+		for region := range regions {
+			if region != "" {
+				data.Regions = append(data.Regions, region)
+			}
+		}
+		sort.Strings(data.Projects)
+		sort.Strings(data.Regions)
+		sort.Slice(data.Rows, func(i, j int) bool {
+			a, b := data.Rows[i], data.Rows[j]
+			if a.Project != b.Project {
+				return a.Project < b.Project
+			}
+			if a.Region != b.Region {
+				return a.Region < b.Region
+			}
+			return a.Metric < b.Metric
+		})
+		sort.SliceStable(data.Rows, func(i, j int) bool { return less(data.Rows[i], data.Rows[j]) })
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := dashboardTemplate.Execute(w, data); err != nil {
+			log.Errorf("Couldn't render dashboard: %v", err)
+		}
+	})
+}
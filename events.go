@@ -0,0 +1,148 @@
+package main
+
+import "time"
+
+// Types of the quota events.
+const (
+	eventThresholdCrossed = "threshold_crossed" // the usage ratio reached the threshold of the quota
+	eventThresholdCleared = "threshold_cleared" // the usage ratio fell below the threshold again
+	eventLimitChanged     = "limit_changed"
+)
+
+// Severities of the threshold events.
+const (
+	severityWarning  = "warning"  // the threshold of the quota
+	severityCritical = "critical" // the critical threshold of the quota
+)
+
+// quotaEvent is a change of the state of a quota between two fetches.
+type quotaEvent struct {
+	Type          string    `json:"type"`
+	Time          time.Time `json:"time"` // fetch time of the quotas showing the change
+	Project       string    `json:"project"`
+	Region        string    `json:"region"`
+	Metric        string    `json:"metric"`
+	Category      string    `json:"category"`
+	Unit          string    `json:"unit"`
+	Limit         float64   `json:"limit"` // negative when unlimited
+	PreviousLimit *float64  `json:"previousLimit,omitempty"`
+	Usage         float64   `json:"usage"`
+	Ratio         float64   `json:"ratio"`               // 0 for the unlimited and zero limits
+	Threshold     float64   `json:"threshold,omitempty"` // threshold of the quota, 0 without one
+	Severity      string    `json:"severity,omitempty"`  // severity of the threshold, empty for limit_changed
+
+	config gcpQuota // config of the project, for the per-project routing of the notifiers
+}
+
+// quotaWatcher turns the fetched quotas of the projects into events, comparing every fetch with the previous
+// one of the project. The threshold and the critical threshold of a quota are crossed and cleared independently,
+// with the warning and critical severity. It isn't safe for concurrent use. A quota at or above its threshold when
+// first seen is reported as crossed, so the quotas over their threshold are reported again after a restart.
+type quotaWatcher struct {
+	defaultThreshold float64 // threshold of the quotas without one in the config, 0 for none
+	defaultCritical  float64 // critical threshold of the quotas without one in the config, 0 for none
+	seen             map[string]*watchedProject
+}
+
+// watchedProject is the state of the last fetch of a project seen by a watcher.
+type watchedProject struct {
+	fetched time.Time
+	quotas  map[string]watchedQuota // by region and metric
+}
+
+type watchedQuota struct {
+	limit    float64
+	over     bool // at or above the threshold
+	critical bool // at or above the critical threshold
+}
+
+func newQuotaWatcher(defaultThreshold, defaultCritical float64) *quotaWatcher {
+	return &quotaWatcher{defaultThreshold: defaultThreshold, defaultCritical: defaultCritical, seen: make(map[string]*watchedProject)}
+}
+
+// threshold returns the threshold of a quota metric of the exporter's project.
+func (w *quotaWatcher) threshold(e *Exporter, metric string) (float64, bool) {
+	if threshold, ok := e.thresholds[metric]; ok {
+		return threshold, true
+	}
+	return w.defaultThreshold, w.defaultThreshold > 0
+}
+
+// critical returns the critical threshold of a quota metric of the exporter's project.
+func (w *quotaWatcher) critical(e *Exporter, metric string) (float64, bool) {
+	if threshold, ok := e.critical[metric]; ok {
+		return threshold, true
+	}
+	return w.defaultCritical, w.defaultCritical > 0
+}
+
+// detect returns the events of the last fetch of the exporter's project since the previous call, by region
+// and metric. A fetch is only compared once. The quotas missing from a partial fetch keep their previous state,
+// so they are neither lost nor reported as crossed again by the next complete fetch.
+func (w *quotaWatcher) detect(e *Exporter) []quotaEvent {
+	previous := w.seen[e.project]
+	res := e.latestResult()
+	if res == nil || (previous != nil && !res.time.After(previous.fetched)) {
+		return nil
+	}
+	quotas, fetched := e.resultQuotas(res), res.time
+
+	var events []quotaEvent
+	config := e.config()
+	current := &watchedProject{fetched: fetched, quotas: make(map[string]watchedQuota, len(quotas))}
+	for _, q := range quotas {
+		key := q.Region + "/" + q.Metric
+		event := quotaEvent{Time: fetched, Project: e.project, Region: q.Region, Metric: q.Metric, Category: q.Category, Unit: q.Unit, Limit: q.Limit, Usage: q.Usage, config: config}
+		if q.Limit > 0 {
+			event.Ratio = q.Usage / q.Limit
+		}
+		state := watchedQuota{limit: q.Limit}
+		threshold, hasThreshold := w.threshold(e, q.Metric)
+		critical, hasCritical := w.critical(e, q.Metric)
+		if q.Limit > 0 {
+			state.over = hasThreshold && event.Ratio >= threshold
+			state.critical = hasCritical && event.Ratio >= critical
+		}
+		current.quotas[key] = state
+
+		last, known := watchedQuota{}, false
+		if previous != nil {
+			last, known = previous.quotas[key]
+		}
+		if known && last.limit != q.Limit {
+			changed := event
+			changed.Type = eventLimitChanged
+			changed.PreviousLimit = &last.limit
+			events = append(events, changed)
+		}
+		levels := []struct {
+			severity   string
+			threshold  float64
+			over, last bool
+		}{
+			{severityWarning, threshold, state.over, last.over},
+			{severityCritical, critical, state.critical, last.critical},
+		}
+		for _, level := range levels {
+			if level.over != level.last && (known || level.over) {
+				crossed := event
+				crossed.Type = eventThresholdCleared
+				if level.over {
+					crossed.Type = eventThresholdCrossed
+				}
+				crossed.Threshold = level.threshold
+				crossed.Severity = level.severity
+				events = append(events, crossed)
+			}
+		}
+	}
+	if previous != nil && !res.complete() {
+		for key, state := range previous.quotas {
+			if _, ok := current.quotas[key]; !ok {
+				current.quotas[key] = state
+			}
+		}
+	}
+	w.seen[e.project] = current
+	return events
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+)
+
+// archiveRow is a quota of an archived snapshot.
+type archiveRow struct {
+	Time     time.Time `json:"time"` // fetch time of the quota
+	Project  string    `json:"project"`
+	Region   string    `json:"region"`
+	Metric   string    `json:"metric"`
+	Category string    `json:"category"`
+	Unit     string    `json:"unit"`
+	Limit    float64   `json:"limit"` // negative when unlimited
+	Usage    float64   `json:"usage"`
+}
+
+// gcsArchiver writes snapshots of the last fetched quotas of all projects to a GCS bucket, an audit trail of
+// the limits independent of the metrics pipeline. A snapshot is a gzip compressed object of newline delimited
+// JSON, one quota per line, named <prefix>/<yyyy>/<mm>/<dd>/gcp-quota-<time>.ndjson.gz.
+type gcsArchiver struct {
+	service  *storage.Service
+	bucket   string
+	prefix   string
+	projects *projectSet
+	leader   *leaderElector
+}
+
+// newGCSArchiver returns an archiver to bucket with the credentials, User-Agent and headers of config.
+// base is the transport of the calls, nil for http.DefaultTransport.
+func newGCSArchiver(config clientConfig, bucket, prefix string, base http.RoundTripper, projects *projectSet, leader *leaderElector) (*gcsArchiver, error) {
+	client, err := sinkClient(config, base)
+	if err != nil {
+		return nil, err
+	}
+	service, err := storage.NewService(context.Background(), option.WithHTTPClient(client))
+	if err != nil {
+		return nil, err
+	}
+	return &gcsArchiver{service: service, bucket: bucket, prefix: prefix, projects: projects, leader: leader}, nil
+}
+
+// run writes a snapshot every interval until ctx is done. Only the leader writes, so the replicas don't
+// archive the same snapshots.
+func (a *gcsArchiver) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if a.leader.leading() {
+			archiveCtx, cancel := context.WithTimeout(ctx, interval)
+			if err := a.archive(archiveCtx, time.Now()); err != nil {
+				pushFailures.WithLabelValues("gcs").Inc()
+				log.Errorf("Couldn't archive the quotas to gs://%s: %v", a.bucket, err)
+			}
+			cancel()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// archive scrapes the projects and writes the snapshot of their last fetched quotas taken at now.
+func (a *gcsArchiver) archive(ctx context.Context, now time.Time) error {
+	// Collecting the projects fetches their quotas when they are scraped on demand.
+	if _, err := gatherProjects(ctx, a.projects); err != nil {
+		log.Warnf("Couldn't gather all metrics for the GCS archive: %v", err)
+	}
+
+	_, exporters := a.projects.get()
+	names := make([]string, 0, len(exporters))
+	for project := range exporters {
+		names = append(names, project)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	encoder := json.NewEncoder(zw)
+	rows := 0
+	for _, project := range names {
+		quotas, fetched, ok := exporters[project].latestQuotas()
+		if !ok {
+			continue
+		}
+		for _, q := range quotas {
+			row := archiveRow{Time: fetched.UTC(), Project: project, Region: q.Region, Metric: q.Metric, Category: q.Category, Unit: q.Unit, Limit: q.Limit, Usage: q.Usage}
+			if err := encoder.Encode(row); err != nil {
+				return err
+			}
+			rows++
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if rows == 0 {
+		log.Warn("No quotas fetched yet, nothing archived to GCS")
+		return nil
+	}
+
+	now = now.UTC()
+	name := path.Join(a.prefix, now.Format("2006/01/02"), fmt.Sprintf("gcp-quota-%s.ndjson.gz", now.Format("20060102T150405Z")))
+	object := &storage.Object{Name: name, ContentType: "application/gzip"}
+	if _, err := a.service.Objects.Insert(a.bucket, object).Media(&buf).Context(ctx).Do(); err != nil {
+		return err
+	}
+	pushSamples.WithLabelValues("gcs").Add(float64(rows))
+	log.Infof("Archived %d quotas to gs://%s/%s", rows, a.bucket, name)
+	return nil
+}
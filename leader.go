@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// proxiedHeader marks the scrapes a standby forwarded to the leader, so they are never forwarded again.
+const proxiedHeader = "X-Gcp-Quota-Exporter-Proxied"
+
+// lease is the part of a coordination.k8s.io/v1 Lease used for the leader election.
+type lease struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   leaseMetadata `json:"metadata"`
+	Spec       leaseSpec     `json:"spec"`
+}
+
+type leaseMetadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions"`
+}
+
+// microTime is the format of the Lease timestamps.
+const microTime = "2006-01-02T15:04:05.000000Z07:00"
+
+// leaderElector elects the replica scraping the Google APIs through a Kubernetes Lease. The holder
+// identity is the URL of the leader, which the standby replicas forward their scrapes to.
+type leaderElector struct {
+	client    *http.Client
+	apiURL    string
+	namespace string
+	name      string
+	identity  string
+	duration  time.Duration
+
+	mutex    sync.RWMutex // protects holder and observed
+	holder   string       // identity of the current leader, empty when unknown
+	observed time.Time    // last time the Lease was read or written
+}
+
+// newLeaderElector returns a leaderElector for the Lease namespace/name using the in-cluster
+// service account. identity is the URL the other replicas reach this one at.
+func newLeaderElector(namespace, name, identity string, duration time.Duration) (*leaderElector, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a Kubernetes cluster")
+	}
+	if identity == "" {
+		return nil, errors.New("the advertise URL is required for the leader election")
+	}
+	if namespace == "" {
+		ns, err := ioutil.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, err
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("invalid service account CA certificate")
+	}
+	return &leaderElector{
+		client: &http.Client{
+			Timeout:   duration / 3,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+		apiURL:    "https://" + net.JoinHostPort(host, port),
+		namespace: namespace,
+		name:      name,
+		identity:  identity,
+		duration:  duration,
+	}, nil
+}
+
+// run takes part in the election until ctx is done, trying to acquire or renew the Lease
+// every third of the lease duration.
+func (l *leaderElector) run(ctx context.Context) {
+	ticker := time.NewTicker(l.duration / 3)
+	defer ticker.Stop()
+	for {
+		holder, err := l.tryAcquireOrRenew(ctx)
+		l.mutex.Lock()
+		if err != nil {
+			log.Errorf("Leader election failed: %v", err)
+			// The last known holder stands until its lease would have expired.
+			holder = l.holder
+			if time.Since(l.observed) > l.duration {
+				holder = ""
+			}
+		} else {
+			l.observed = time.Now()
+		}
+		if holder != l.holder {
+			log.Infof("Leader changed from %q to %q", l.holder, holder)
+		}
+		l.holder = holder
+		l.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// leading tells whether this replica is the leader, nil elects every replica.
+func (l *leaderElector) leading() bool {
+	if l == nil {
+		return true
+	}
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return l.holder == l.identity
+}
+
+// leader returns the identity of the current leader, empty when unknown.
+func (l *leaderElector) leader() string {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return l.holder
+}
+
+// tryAcquireOrRenew takes the Lease when it is free or expired, renews it when held, and returns the holder.
+func (l *leaderElector) tryAcquireOrRenew(ctx context.Context) (string, error) {
+	path := fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases", l.namespace)
+	now := time.Now()
+
+	var current lease
+	code, err := l.do(ctx, http.MethodGet, path+"/"+l.name, nil, &current)
+	if err != nil {
+		return "", err
+	}
+	if code == http.StatusNotFound {
+		created := lease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   leaseMetadata{Name: l.name, Namespace: l.namespace},
+			Spec: leaseSpec{
+				HolderIdentity:       l.identity,
+				LeaseDurationSeconds: int(l.duration.Seconds()),
+				AcquireTime:          now.Format(microTime),
+				RenewTime:            now.Format(microTime),
+			},
+		}
+		code, err = l.do(ctx, http.MethodPost, path, created, nil)
+		return l.result(code, err, "")
+	}
+	if code != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d getting lease %s/%s", code, l.namespace, l.name)
+	}
+
+	renewed, _ := time.Parse(microTime, current.Spec.RenewTime)
+	expiry := renewed.Add(time.Duration(current.Spec.LeaseDurationSeconds) * time.Second)
+	if current.Spec.HolderIdentity != l.identity && current.Spec.HolderIdentity != "" && now.Before(expiry) {
+		return current.Spec.HolderIdentity, nil
+	}
+
+	update := current
+	if current.Spec.HolderIdentity != l.identity {
+		update.Spec.HolderIdentity = l.identity
+		update.Spec.AcquireTime = now.Format(microTime)
+		update.Spec.LeaseTransitions++
+	}
+	update.Spec.LeaseDurationSeconds = int(l.duration.Seconds())
+	update.Spec.RenewTime = now.Format(microTime)
+	code, err = l.do(ctx, http.MethodPut, path+"/"+l.name, update, nil)
+	return l.result(code, err, current.Spec.HolderIdentity)
+}
+
+// result returns the holder after writing the Lease: this replica on success, previous when
+// another replica updated the Lease first.
+func (l *leaderElector) result(code int, err error, previous string) (string, error) {
+	switch {
+	case err != nil:
+		return "", err
+	case code == http.StatusOK || code == http.StatusCreated:
+		return l.identity, nil
+	case code == http.StatusConflict:
+		return previous, nil
+	}
+	return "", fmt.Errorf("unexpected status %d writing lease %s/%s", code, l.namespace, l.name)
+}
+
+// do sends a request to the Kubernetes API and decodes a 200 response into out.
+func (l *leaderElector) do(ctx context.Context, method, path string, in, out interface{}) (int, error) {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return 0, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, l.apiURL+path, &body)
+	if err != nil {
+		return 0, err
+	}
+	// The token is read on every request, as projected service account tokens are rotated.
+	token, err := ioutil.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK && out != nil {
+		return resp.StatusCode, json.NewDecoder(resp.Body).Decode(out)
+	}
+	return resp.StatusCode, nil
+}
+
+// proxy forwards a scrape of a standby replica to the leader. It returns false when the scrape
+// has to be served locally: this replica leads, the leader is unknown, or the scrape was forwarded already.
+func (l *leaderElector) proxy(w http.ResponseWriter, r *http.Request) bool {
+	if l == nil || l.leading() || r.Header.Get(proxiedHeader) != "" {
+		return false
+	}
+	leader := l.leader()
+	if leader == "" {
+		return false
+	}
+	target, err := url.Parse(leader)
+	if err != nil {
+		log.Errorf("Invalid leader URL %q: %v", leader, err)
+		return false
+	}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		director(req)
+		req.Header.Set(proxiedHeader, "1")
+	}
+	proxy.ServeHTTP(w, r)
+	return true
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
+
+	"google.golang.org/api/serviceusage/v1beta1"
+)
+
+const computeServiceName = "compute.googleapis.com"
+
+// quotaMetricInfo is the human readable description of a quota metric.
+type quotaMetricInfo struct {
+	metric      string
+	displayName string
+	service     string
+}
+
+// metricInfoExporter exports gcp_quota_metric_info with the display names of the Compute quotas.
+// Display names are the same for every project, so they are fetched once with the
+// clients of a single configured project, sharing its settings, rate limits and retries.
+type metricInfoExporter struct {
+	exporter *Exporter
+	infos    []quotaMetricInfo
+	group    singleflight.Group // coalesces the fetches of concurrent collects
+	mutex    sync.RWMutex       // protects infos, never held during Google API calls
+}
+
+// NewMetricInfoExporter returns a metricInfoExporter fetching the display names with the clients of exporter.
+func NewMetricInfoExporter(exporter *Exporter) *metricInfoExporter {
+	return &metricInfoExporter{exporter: exporter}
+}
+
+// collect exports the display names, fetching them on the first collect with the call bound to ctx.
+func (e *metricInfoExporter) collect(ctx context.Context, ch chan<- prometheus.Metric) {
+	e.mutex.RLock()
+	infos := e.infos
+	e.mutex.RUnlock()
+
+	if infos == nil {
+		res, _, _ := e.group.Do("scrape", func() (interface{}, error) {
+			infos := e.scrape(ctx)
+			if infos != nil {
+				e.mutex.Lock()
+				e.infos = infos
+				e.mutex.Unlock()
+			}
+			return infos, nil
+		})
+		infos = res.([]quotaMetricInfo)
+	}
+	for _, info := range infos {
+		ch <- prometheus.MustNewConstMetric(metricInfoDesc, prometheus.GaugeValue, 1, info.metric, info.displayName, info.service)
+	}
+}
+
+// scrape lists the Compute consumer quota metrics from the Service Usage API, a call per page.
+func (e *metricInfoExporter) scrape(ctx context.Context) []quotaMetricInfo {
+	if err := e.exporter.waitInit(ctx); err != nil {
+		contextLogger(ctx).Errorf("Not querying quota metric descriptions: %v", err)
+		return nil
+	}
+	project := e.exporter.project
+	service := e.exporter.clients.serviceusage
+
+	var infos []quotaMetricInfo
+	seen := make(map[string]bool)
+	parent := "projects/" + project + "/services/" + computeServiceName
+	for pageToken := ""; ; {
+		var page *serviceusage.ListConsumerQuotaMetricsResponse
+		_, err := e.exporter.call(ctx, "metric_info", func(ctx context.Context) error {
+			c := service.Services.ConsumerQuotaMetrics.List(parent).View("BASIC").PageToken(pageToken)
+			tagAPICall(ctx, c.Header())
+			var err error
+			page, err = c.Context(ctx).Do()
+			return err
+		})
+		if err != nil {
+			contextLogger(ctx).Errorf("Failure when querying quota metric descriptions: %v", err)
+			recordAPIError(project, "metric_info", err)
+			return nil
+		}
+		for _, m := range page.Metrics {
+			metric := computeQuotaMetricName(m.Metric)
+			if metric == "" || seen[metric] {
+				continue
+			}
+			seen[metric] = true
+			infos = append(infos, quotaMetricInfo{metric: metric, displayName: m.DisplayName, service: computeServiceName})
+		}
+		if pageToken = page.NextPageToken; pageToken == "" {
+			break
+		}
+	}
+	return infos
+}
+
+// computeQuotaMetricName maps a Service Usage metric (compute.googleapis.com/in_use_addresses)
+// to the name used by the Compute API quotas (IN_USE_ADDRESSES).
+func computeQuotaMetricName(metric string) string {
+	name := strings.TrimPrefix(metric, computeServiceName+"/")
+	if name == metric {
+		return ""
+	}
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sort"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+// collectOnce scrapes all projects once and writes their metrics along with the exporter's own in the
+// text format to out. It returns the projects whose project or region quotas couldn't be fetched.
+func collectOnce(ctx context.Context, projects *projectSet, out io.Writer) ([]string, error) {
+	families, err := gatherProjects(ctx, projects)
+	if err != nil {
+		return nil, err
+	}
+	encoder := expfmt.NewEncoder(out, expfmt.FmtText)
+	for _, family := range families {
+		if err := encoder.Encode(family); err != nil {
+			return nil, err
+		}
+	}
+
+	var failed []string
+	_, exporters := projects.get()
+	for project, exporter := range exporters {
+		exporter.statusMutex.RLock()
+		status := exporter.status
+		exporter.statusMutex.RUnlock()
+		if !status.Up || status.Error != "" || len(status.RegionErrors) > 0 {
+			failed = append(failed, project)
+		}
+	}
+	sort.Strings(failed)
+	return failed, nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// OTLP protocols the metrics can be exported with.
+const (
+	otlpGRPC = "grpc"
+	otlpHTTP = "http/protobuf"
+)
+
+// otlpScope is the instrumentation scope of the exported metrics.
+const otlpScope = "prometheus-exporter-gcp-quota"
+
+// otlpExporter exports the metrics to an OpenTelemetry Collector, or any other OTLP receiver, every interval.
+// The Prometheus counters, gauges, histograms and summaries map to cumulative OTLP sums, gauges, histograms
+// and summaries, with the labels as attributes.
+type otlpExporter struct {
+	endpoint string
+	headers  map[string]string
+	projects *projectSet
+	leader   *leaderElector
+	start    time.Time // start time of the cumulative metrics
+
+	grpc *grpc.ClientConn // nil with OTLP/HTTP
+	http *http.Client
+}
+
+// newOTLPExporter returns an exporter to endpoint, host:port with gRPC and a URL with OTLP/HTTP, to which /v1/metrics
+// is added when it has no path. insecure disables TLS with gRPC, OTLP/HTTP uses the scheme of the URL.
+func newOTLPExporter(endpoint, protocol string, insecureGRPC bool, headers string, timeout time.Duration, projects *projectSet, leader *leaderElector) (*otlpExporter, error) {
+	parsed, err := parseHeaders(headers)
+	if err != nil {
+		return nil, err
+	}
+	e := &otlpExporter{endpoint: endpoint, headers: parsed, projects: projects, leader: leader, start: time.Now()}
+
+	switch protocol {
+	case otlpGRPC:
+		creds := credentials.NewTLS(&tls.Config{})
+		if insecureGRPC {
+			creds = insecure.NewCredentials()
+		}
+		conn, err := grpc.Dial(endpoint, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return nil, err
+		}
+		e.grpc = conn
+	case otlpHTTP:
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("invalid OTLP/HTTP endpoint %q, must be an http or https URL", endpoint)
+		}
+		if u.Path == "" || u.Path == "/" {
+			u.Path = "/v1/metrics"
+		}
+		e.endpoint = u.String()
+		e.http = &http.Client{Timeout: timeout}
+	default:
+		return nil, fmt.Errorf("unknown OTLP protocol %q, must be %s or %s", protocol, otlpGRPC, otlpHTTP)
+	}
+	return e, nil
+}
+
+// run exports the metrics every interval until ctx is done, each export bounded by timeout. Only the
+// leader exports, so the replicas don't send the same metrics.
+func (e *otlpExporter) run(ctx context.Context, interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if e.leader.leading() {
+			e.export(ctx, interval, timeout)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// export collects the metrics of all projects within interval and sends them within timeout.
+func (e *otlpExporter) export(ctx context.Context, interval, timeout time.Duration) {
+	collectCtx, cancel := context.WithTimeout(ctx, interval)
+	families, err := gatherProjects(collectCtx, e.projects)
+	cancel()
+	if err != nil {
+		// The families gathered without an error are exported anyway.
+		log.Warnf("Couldn't gather all metrics for OTLP: %v", err)
+	}
+	request, points := e.request(families, time.Now())
+
+	sendCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := e.send(sendCtx, request); err != nil {
+		pushFailures.WithLabelValues("otlp").Inc()
+		log.Errorf("OTLP export failed: %v", err)
+		return
+	}
+	pushSamples.WithLabelValues("otlp").Add(float64(points))
+}
+
+// send exports request over gRPC or OTLP/HTTP.
+func (e *otlpExporter) send(ctx context.Context, request *colmetricpb.ExportMetricsServiceRequest) error {
+	if e.grpc != nil {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(e.headers))
+		_, err := colmetricpb.NewMetricsServiceClient(e.grpc).Export(ctx, request)
+		return err
+	}
+
+	body, err := proto.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, value := range e.headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	resp, err := e.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// request converts the metric families into an export request and returns it along with its number of data points.
+func (e *otlpExporter) request(families []*dto.MetricFamily, now time.Time) (*colmetricpb.ExportMetricsServiceRequest, int) {
+	start := uint64(e.start.UnixNano())
+	metrics := make([]*metricpb.Metric, 0, len(families))
+	points := 0
+	for _, family := range families {
+		metric := &metricpb.Metric{Name: family.GetName(), Description: family.GetHelp()}
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			sum := &metricpb.Sum{AggregationTemporality: metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE, IsMonotonic: true}
+			for _, m := range family.GetMetric() {
+				sum.DataPoints = append(sum.DataPoints, numberDataPoint(m, m.GetCounter().GetValue(), start, now))
+			}
+			metric.Data = &metricpb.Metric_Sum{Sum: sum}
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			gauge := &metricpb.Gauge{}
+			for _, m := range family.GetMetric() {
+				value := m.GetGauge().GetValue()
+				if family.GetType() == dto.MetricType_UNTYPED {
+					value = m.GetUntyped().GetValue()
+				}
+				gauge.DataPoints = append(gauge.DataPoints, numberDataPoint(m, value, 0, now))
+			}
+			metric.Data = &metricpb.Metric_Gauge{Gauge: gauge}
+		case dto.MetricType_HISTOGRAM:
+			histogram := &metricpb.Histogram{AggregationTemporality: metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE}
+			for _, m := range family.GetMetric() {
+				h := m.GetHistogram()
+				sum := h.GetSampleSum()
+				point := &metricpb.HistogramDataPoint{
+					Attributes:        otlpAttributes(m.GetLabel()),
+					StartTimeUnixNano: start,
+					TimeUnixNano:      otlpTime(m, now),
+					Count:             h.GetSampleCount(),
+					Sum:               &sum,
+				}
+				// OTLP buckets hold the count of their own range, the +Inf bucket is implicit.
+				var previous uint64
+				for _, b := range h.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), 1) {
+						continue
+					}
+					point.ExplicitBounds = append(point.ExplicitBounds, b.GetUpperBound())
+					point.BucketCounts = append(point.BucketCounts, b.GetCumulativeCount()-previous)
+					previous = b.GetCumulativeCount()
+				}
+				point.BucketCounts = append(point.BucketCounts, h.GetSampleCount()-previous)
+				histogram.DataPoints = append(histogram.DataPoints, point)
+			}
+			metric.Data = &metricpb.Metric_Histogram{Histogram: histogram}
+		case dto.MetricType_SUMMARY:
+			summary := &metricpb.Summary{}
+			for _, m := range family.GetMetric() {
+				s := m.GetSummary()
+				point := &metricpb.SummaryDataPoint{
+					Attributes:        otlpAttributes(m.GetLabel()),
+					StartTimeUnixNano: start,
+					TimeUnixNano:      otlpTime(m, now),
+					Count:             s.GetSampleCount(),
+					Sum:               s.GetSampleSum(),
+				}
+				for _, q := range s.GetQuantile() {
+					point.QuantileValues = append(point.QuantileValues, &metricpb.SummaryDataPoint_ValueAtQuantile{Quantile: q.GetQuantile(), Value: q.GetValue()})
+				}
+				summary.DataPoints = append(summary.DataPoints, point)
+			}
+			metric.Data = &metricpb.Metric_Summary{Summary: summary}
+		default:
+			continue
+		}
+		points += len(family.GetMetric())
+		metrics = append(metrics, metric)
+	}
+
+	return &colmetricpb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricpb.ResourceMetrics{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{otlpAttribute("service.name", otlpScope)}},
+			ScopeMetrics: []*metricpb.ScopeMetrics{{
+				Scope:   &commonpb.InstrumentationScope{Name: otlpScope},
+				Metrics: metrics,
+			}},
+		}},
+	}, points
+}
+
+func numberDataPoint(m *dto.Metric, value float64, start uint64, now time.Time) *metricpb.NumberDataPoint {
+	return &metricpb.NumberDataPoint{
+		Attributes:        otlpAttributes(m.GetLabel()),
+		StartTimeUnixNano: start,
+		TimeUnixNano:      otlpTime(m, now),
+		Value:             &metricpb.NumberDataPoint_AsDouble{AsDouble: value},
+	}
+}
+
+// otlpTime returns the timestamp of m in nanoseconds, now when it has none.
+func otlpTime(m *dto.Metric, now time.Time) uint64 {
+	if m.TimestampMs != nil {
+		return uint64(m.GetTimestampMs()) * uint64(time.Millisecond)
+	}
+	return uint64(now.UnixNano())
+}
+
+func otlpAttributes(labels []*dto.LabelPair) []*commonpb.KeyValue {
+	attributes := make([]*commonpb.KeyValue, 0, len(labels))
+	for _, l := range labels {
+		attributes = append(attributes, otlpAttribute(l.GetName(), l.GetValue()))
+	}
+	return attributes
+}
+
+func otlpAttribute(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
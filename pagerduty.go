@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// pagerDutyEventsURL is the endpoint of the PagerDuty Events API v2.
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// pagerDutyDefaultTeam is the team of the routing keys of the projects without a pagerduty_team or with a
+// team missing from the routing keys.
+const pagerDutyDefaultTeam = "default"
+
+// pagerDutyRoutingKeys are the routing keys of the PagerDuty services by team and severity, e.g.
+//
+//	default:
+//	  critical: <routing key of the on-call service>
+//	network:
+//	  critical: <routing key of the network team>
+//	  warning: <routing key of a low urgency service of the network team>
+//
+// The events of a severity without a routing key aren't sent.
+type pagerDutyRoutingKeys map[string]map[string]string
+
+// pagerDutyEvent is an event of the Events API v2, the payload is only set for a trigger.
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"` // trigger or resolve
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string     `json:"summary"`
+	Source        string     `json:"source"`
+	Severity      string     `json:"severity"`
+	Timestamp     time.Time  `json:"timestamp"`
+	Component     string     `json:"component"`
+	Group         string     `json:"group"`
+	Class         string     `json:"class"`
+	CustomDetails quotaEvent `json:"custom_details"`
+}
+
+// pagerDutyNotifier triggers a PagerDuty alert when a quota crosses its threshold and resolves it when the
+// threshold is cleared, so the exhaustion of a critical quota can page directly. The routing key of an event
+// depends on the pagerduty_team of its project and on its severity.
+type pagerDutyNotifier struct {
+	url      string
+	keysFile string // YAML file of the pagerDutyRoutingKeys, read on every delivery so the keys can be rotated
+	client   *http.Client
+}
+
+// newPagerDutyNotifier returns a notifier sending the events to the Events API at eventsURL with the routing
+// keys of keysFile.
+func newPagerDutyNotifier(eventsURL, keysFile string, timeout time.Duration) (*pagerDutyNotifier, error) {
+	if u, err := url.Parse(eventsURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid PagerDuty URL %q", eventsURL)
+	}
+	if _, err := readPagerDutyRoutingKeys(keysFile); err != nil {
+		return nil, err
+	}
+	return &pagerDutyNotifier{url: eventsURL, keysFile: keysFile, client: &http.Client{Timeout: timeout}}, nil
+}
+
+// readPagerDutyRoutingKeys reads and checks the routing keys of file.
+func readPagerDutyRoutingKeys(file string) (pagerDutyRoutingKeys, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var keys pagerDutyRoutingKeys
+	if err := yaml.UnmarshalStrict(content, &keys); err != nil {
+		return nil, fmt.Errorf("invalid PagerDuty routing keys in %s: %v", file, err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no PagerDuty routing keys in %s", file)
+	}
+	for team, severities := range keys {
+		for severity, key := range severities {
+			if severity != severityWarning && severity != severityCritical {
+				return nil, fmt.Errorf("invalid severity %q of team %s in %s, must be %s or %s", severity, team, file, severityWarning, severityCritical)
+			}
+			if strings.TrimSpace(key) == "" {
+				return nil, fmt.Errorf("empty %s routing key of team %s in %s", severity, team, file)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// routingKey returns the routing key of the events of a team and severity, empty when they aren't sent.
+// A team missing from the keys gets those of the default team.
+func (k pagerDutyRoutingKeys) routingKey(team, severity string) string {
+	severities, ok := k[team]
+	if !ok || team == "" {
+		severities = k[pagerDutyDefaultTeam]
+	}
+	return strings.TrimSpace(severities[severity])
+}
+
+// deliver sends the events with a routing key.
+func (n *pagerDutyNotifier) deliver(ctx context.Context, events []quotaEvent) (int, error) {
+	keys, err := readPagerDutyRoutingKeys(n.keysFile)
+	if err != nil {
+		return 0, err
+	}
+	for i, event := range events {
+		key := keys.routingKey(event.config.PagerDutyTeam, event.Severity)
+		if key == "" {
+			continue
+		}
+		if err := n.send(ctx, key, event); err != nil {
+			return i, err
+		}
+	}
+	return len(events), nil
+}
+
+// send triggers or resolves the alert of event. The alerts of a quota are deduplicated by project, region,
+// metric and severity, so the warning and critical alerts are resolved separately.
+func (n *pagerDutyNotifier) send(ctx context.Context, key string, event quotaEvent) error {
+	region := event.Region
+	if region == "" {
+		region = "global"
+	}
+	message := pagerDutyEvent{
+		RoutingKey:  key,
+		EventAction: "resolve",
+		DedupKey:    strings.Join([]string{"gcp-quota", event.Project, region, event.Metric, event.Severity}, "/"),
+	}
+	if event.Type == eventThresholdCrossed {
+		message.EventAction = "trigger"
+		message.Payload = &pagerDutyPayload{
+			Summary:       fmt.Sprintf("GCP quota %s of %s in %s at %s of its limit (%s of %s)", event.Metric, event.Project, region, formatPercent(event.Ratio), formatNumber(event.Usage), formatNumber(event.Limit)),
+			Source:        event.Project,
+			Severity:      event.Severity,
+			Timestamp:     event.Time,
+			Component:     event.Metric,
+			Group:         region,
+			Class:         "gcp_quota",
+			CustomDetails: event,
+		}
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	content, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	switch {
+	case resp.StatusCode == http.StatusBadRequest:
+		// An invalid event is rejected again on every retry, it would hold up the following ones.
+		log.Errorf("PagerDuty rejected the %s event of %s in %s: %s", event.Metric, event.Project, region, strings.TrimSpace(string(content)))
+		return nil
+	case resp.StatusCode/100 != 2:
+		return fmt.Errorf("PagerDuty returned %s: %s", resp.Status, strings.TrimSpace(string(content)))
+	}
+	return nil
+}
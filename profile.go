@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"google.golang.org/api/compute/v1"
+)
+
+// Scrape profiles choose the Google API calls made for a project, so the API budget is spent where it matters.
+const (
+	profileLight = "light" // project quotas only
+	profileFull  = "full"  // project and region quotas
+	profileDeep  = "deep"  // project and region quotas and the per-network limits
+)
+
+// networkFields is the field mask of the networks call of the deep profile.
+const networkFields = "items(name,subnetworks,peerings(name)),nextPageToken"
+
+// checkProfile fails for an unknown scrape profile, empty selects the default profile.
+func checkProfile(profile string) error {
+	switch profile {
+	case "", profileLight, profileFull, profileDeep:
+		return nil
+	}
+	return fmt.Errorf("unknown scrape profile %q, must be %s, %s or %s", profile, profileLight, profileFull, profileDeep)
+}
+
+// scrapeProfile returns the scrape profile of the project, opts.profile when the config doesn't set one.
+func (e *Exporter) scrapeProfile() string {
+	if e.profile != "" {
+		return e.profile
+	}
+	if e.opts.profile != "" {
+		return e.opts.profile
+	}
+	return profileFull
+}
+
+// scrapeNetworks fetches the networks of the project for the deep profile. The subnetworks and
+// peerings of a network have limits of their own, which aren't part of the Compute API quotas.
+func (e *Exporter) scrapeNetworks(ctx context.Context, res *scrapeResult) {
+	// Non-nil tells a project without networks from a failed call.
+	networks := []*compute.Network{}
+	// Every page is a call of its own, counted and rate limited as such.
+	for pageToken := ""; ; {
+		var page *compute.NetworkList
+		attempts, err := e.call(ctx, "networks", func(ctx context.Context) error {
+			c := e.service.Networks.List(e.project).Fields(networkFields).PageToken(pageToken)
+			tagAPICall(ctx, c.Header())
+			var err error
+			page, err = c.Context(ctx).Do()
+			return err
+		})
+		res.apiCalls += attempts
+		if err != nil {
+			contextLogger(ctx).Errorf("Failure when querying networks: %v", err)
+			recordAPIError(e.project, "networks", err)
+			return
+		}
+		networks = append(networks, page.Items...)
+		if pageToken = page.NextPageToken; pageToken == "" {
+			break
+		}
+	}
+	sort.Slice(networks, func(i, j int) bool { return networks[i].Name < networks[j].Name })
+	res.networks = networks
+}
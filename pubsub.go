@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/pubsub/v1"
+)
+
+// pubsubBatch is the maximum number of messages of a publish call.
+const pubsubBatch = 1000
+
+// pubsubPublisher publishes the quota events to a Pub/Sub topic, for automation like quota increase pipelines
+// subscribing to the changes instead of polling Prometheus. Every event is a message with the event as JSON
+// and its type, project, region and metric as attributes, to filter the subscriptions on.
+type pubsubPublisher struct {
+	service *pubsub.Service
+	topic   string // projects/<project>/topics/<topic>
+}
+
+// newPubSubPublisher returns a publisher to topic with the credentials, User-Agent and headers of config.
+// base is the transport of the calls, nil for http.DefaultTransport.
+func newPubSubPublisher(config clientConfig, topic string, base http.RoundTripper) (*pubsubPublisher, error) {
+	parts := strings.Split(topic, "/")
+	if len(parts) != 4 || parts[0] != "projects" || parts[1] == "" || parts[2] != "topics" || parts[3] == "" {
+		return nil, fmt.Errorf("invalid Pub/Sub topic %q, must be projects/<project>/topics/<topic>", topic)
+	}
+	client, err := sinkClient(config, base)
+	if err != nil {
+		return nil, err
+	}
+	service, err := pubsub.NewService(context.Background(), option.WithHTTPClient(client))
+	if err != nil {
+		return nil, err
+	}
+	return &pubsubPublisher{service: service, topic: topic}, nil
+}
+
+// deliver publishes the events in calls of up to pubsubBatch messages.
+func (p *pubsubPublisher) deliver(ctx context.Context, events []quotaEvent) (int, error) {
+	for start := 0; start < len(events); start += pubsubBatch {
+		end := start + pubsubBatch
+		if end > len(events) {
+			end = len(events)
+		}
+		request := &pubsub.PublishRequest{Messages: make([]*pubsub.PubsubMessage, 0, end-start)}
+		for _, event := range events[start:end] {
+			data, err := json.Marshal(event)
+			if err != nil {
+				return start, err
+			}
+			request.Messages = append(request.Messages, &pubsub.PubsubMessage{
+				Data: base64.StdEncoding.EncodeToString(data),
+				Attributes: map[string]string{
+					"type":    event.Type,
+					"project": event.Project,
+					"region":  event.Region,
+					"metric":  event.Metric,
+				},
+			})
+		}
+		if _, err := p.service.Projects.Topics.Publish(p.topic, request).Context(ctx).Do(); err != nil {
+			return start, err
+		}
+	}
+	return len(events), nil
+}
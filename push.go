@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// pushClientOptions configure the HTTP client pushing the metrics to an endpoint which can't scrape the exporter.
+type pushClientOptions struct {
+	timeout            time.Duration
+	bearerTokenFile    string
+	username           string
+	passwordFile       string
+	caFile             string
+	certFile           string
+	keyFile            string
+	insecureSkipVerify bool
+}
+
+// client returns the HTTP client of o. The token and password files are read on every request, so they can be rotated.
+func (o pushClientOptions) client() (*http.Client, error) {
+	if o.bearerTokenFile != "" && (o.username != "" || o.passwordFile != "") {
+		return nil, errors.New("a bearer token and basic auth can't be used together")
+	}
+	if (o.certFile == "") != (o.keyFile == "") {
+		return nil, errors.New("both the TLS certificate and key files must be set")
+	}
+	config := &tls.Config{InsecureSkipVerify: o.insecureSkipVerify}
+	if o.caFile != "" {
+		ca, err := ioutil.ReadFile(o.caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", o.caFile)
+		}
+	}
+	if o.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.certFile, o.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Timeout: o.timeout, Transport: &authTransport{base: transport, options: o}}, nil
+}
+
+// readSecret returns the content of a file holding a secret, without the surrounding whitespace.
+func readSecret(file string) (string, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// authTransport adds the credentials of the push client options to the requests.
+type authTransport struct {
+	base    http.RoundTripper
+	options pushClientOptions
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	o := t.options
+	if o.bearerTokenFile == "" && o.username == "" {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	if o.bearerTokenFile != "" {
+		token, err := readSecret(o.bearerTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else {
+		var password string
+		if o.passwordFile != "" {
+			var err error
+			if password, err = readSecret(o.passwordFile); err != nil {
+				return nil, err
+			}
+		}
+		req.SetBasicAuth(o.username, password)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// gatherProjects collects the metrics of all projects along with the exporter's own metrics, for the
+// sinks pushing them. The Google API calls of the projects fetched on demand are bound to ctx.
+func gatherProjects(ctx context.Context, projects *projectSet) ([]*dto.MetricFamily, error) {
+	_, all := projects.get()
+	names := make([]string, 0, len(all))
+	for project := range all {
+		names = append(names, project)
+	}
+	sort.Strings(names)
+	exporters := make([]*Exporter, 0, len(names))
+	for _, project := range names {
+		exporters = append(exporters, all[project])
+	}
+	return append(projects.ownGatherers(ctx), projectRegistry(ctx, exporters...)).Gather()
+}
+
+// flatSeries is a sample along with its sorted labels, the metric name included.
+type flatSeries struct {
+	labels    []*dto.LabelPair
+	value     float64
+	timestamp int64
+}
+
+// flattenFamilies flattens the metric families into series the way they are exposed: histograms
+// as their _bucket, _sum and _count series, summaries as their quantiles, _sum and _count. Samples
+// without a timestamp of their own get now, in milliseconds.
+func flattenFamilies(families []*dto.MetricFamily, now int64) []flatSeries {
+	var series []flatSeries
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			timestamp := now
+			if m.TimestampMs != nil {
+				timestamp = m.GetTimestampMs()
+			}
+			add := func(name string, value float64, extra ...*dto.LabelPair) {
+				labels := make([]*dto.LabelPair, 0, len(m.GetLabel())+len(extra)+1)
+				labels = append(labels, labelPair("__name__", name))
+				labels = append(labels, m.GetLabel()...)
+				labels = append(labels, extra...)
+				sort.Slice(labels, func(i, j int) bool { return labels[i].GetName() < labels[j].GetName() })
+				series = append(series, flatSeries{labels: labels, value: value, timestamp: timestamp})
+			}
+			name := family.GetName()
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				for _, q := range m.GetSummary().GetQuantile() {
+					add(name, q.GetValue(), labelPair("quantile", formatFloat(q.GetQuantile())))
+				}
+				add(name+"_sum", m.GetSummary().GetSampleSum())
+				add(name+"_count", float64(m.GetSummary().GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				infSeen := false
+				for _, b := range m.GetHistogram().GetBucket() {
+					infSeen = infSeen || math.IsInf(b.GetUpperBound(), 1)
+					add(name+"_bucket", float64(b.GetCumulativeCount()), labelPair("le", formatFloat(b.GetUpperBound())))
+				}
+				if !infSeen {
+					add(name+"_bucket", float64(m.GetHistogram().GetSampleCount()), labelPair("le", "+Inf"))
+				}
+				add(name+"_sum", m.GetHistogram().GetSampleSum())
+				add(name+"_count", float64(m.GetHistogram().GetSampleCount()))
+			}
+		}
+	}
+	return series
+}
+
+func labelPair(name, value string) *dto.LabelPair {
+	return &dto.LabelPair{Name: &name, Value: &value}
+}
+
+// formatFloat formats the le and quantile label values like the text exposition format.
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+	log "github.com/sirupsen/logrus"
+)
+
+// pushgatewayPusher pushes the metrics of every project to a Prometheus Pushgateway, in a group of its own
+// with the grouping key job=<job>,project=<project>. The prometheus/push package can't be used, as it
+// refuses metrics carrying a grouping label and every quota series has a project label.
+type pushgatewayPusher struct {
+	url      string
+	job      string
+	client   *http.Client
+	projects *projectSet
+	leader   *leaderElector
+
+	pushed map[string]bool // projects with a group on the Pushgateway
+}
+
+// run pushes every interval until ctx is done. Only the leader pushes, so the replicas don't
+// overwrite each other's groups.
+func (p *pushgatewayPusher) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if p.leader.leading() {
+			pushCtx, cancel := context.WithTimeout(ctx, interval)
+			p.push(pushCtx)
+			cancel()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// push replaces the group of every project with its current metrics, and deletes the groups of the
+// projects removed from the config since the last push. It returns the number of failed pushes.
+func (p *pushgatewayPusher) push(ctx context.Context) int {
+	_, exporters := p.projects.get()
+	projects := make([]string, 0, len(exporters))
+	for project := range exporters {
+		projects = append(projects, project)
+	}
+	sort.Strings(projects)
+
+	failures := 0
+	current := make(map[string]bool, len(projects))
+	for _, project := range projects {
+		current[project] = true
+		families, err := projectRegistry(ctx, exporters[project]).Gather()
+		if err == nil {
+			var body bytes.Buffer
+			encoder := expfmt.NewEncoder(&body, expfmt.FmtProtoDelim)
+			for _, family := range families {
+				if err = encoder.Encode(family); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				err = p.send(ctx, http.MethodPut, project, &body)
+			}
+		}
+		if err != nil {
+			failures++
+			pushFailures.WithLabelValues("pushgateway").Inc()
+			log.Errorf("Couldn't push %s to the Pushgateway: %v", project, err)
+			continue
+		}
+		samples := 0
+		for _, family := range families {
+			samples += len(family.GetMetric())
+		}
+		pushSamples.WithLabelValues("pushgateway").Add(float64(samples))
+	}
+
+	for project := range p.pushed {
+		if current[project] {
+			continue
+		}
+		if err := p.send(ctx, http.MethodDelete, project, nil); err != nil {
+			// Deleted again by the next push.
+			current[project] = true
+			log.Errorf("Couldn't delete the Pushgateway group of %s: %v", project, err)
+		}
+	}
+	p.pushed = current
+	return failures
+}
+
+// send makes a request on the group of project.
+func (p *pushgatewayPusher) send(ctx context.Context, method, project string, body io.Reader) error {
+	target := fmt.Sprintf("%s/metrics/job/%s/project/%s", strings.TrimSuffix(p.url, "/"), url.PathEscape(p.job), url.PathEscape(project))
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", string(expfmt.FmtProtoDelim))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
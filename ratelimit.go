@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimits throttle the Google API calls of all exporters, so the exporter stays within the
+// read request quota of the credential projects. Every credential has a bucket of its own,
+// the global bucket is shared by all of them.
+type rateLimits struct {
+	perCredential float64 // requests per second of a credential, 0 for no limit
+	global        *rate.Limiter
+	limiters      map[string]*rate.Limiter
+	mutex         sync.Mutex
+}
+
+// newRateLimits returns the rate limits, nil when neither of them is set.
+func newRateLimits(perCredential, global float64) *rateLimits {
+	if perCredential <= 0 && global <= 0 {
+		return nil
+	}
+	l := &rateLimits{perCredential: perCredential, limiters: make(map[string]*rate.Limiter)}
+	if global > 0 {
+		l.global = newLimiter(global)
+	}
+	return l
+}
+
+// newLimiter returns a token bucket refilled at rps tokens per second, holding up to a second of tokens.
+func newLimiter(rps float64) *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(rps), int(math.Max(1, math.Ceil(rps))))
+}
+
+// wait blocks until a call with credentials is allowed by both limits and returns the time waited.
+// It fails right away when the wait would exceed the deadline of ctx.
+func (l *rateLimits) wait(ctx context.Context, credentials string) (time.Duration, error) {
+	if l == nil {
+		return 0, nil
+	}
+	start := time.Now()
+	if l.perCredential > 0 {
+		l.mutex.Lock()
+		limiter, ok := l.limiters[credentials]
+		if !ok {
+			limiter = newLimiter(l.perCredential)
+			l.limiters[credentials] = limiter
+		}
+		l.mutex.Unlock()
+		if err := limiter.Wait(ctx); err != nil {
+			return time.Since(start), err
+		}
+	}
+	if l.global != nil {
+		if err := l.global.Wait(ctx); err != nil {
+			return time.Since(start), err
+		}
+	}
+	return time.Since(start), nil
+}
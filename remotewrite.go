@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// maxSamplesPerSend splits the samples of a collection into remote write requests of this size.
+const maxSamplesPerSend = 2000
+
+// remoteWriter pushes the metrics to a Prometheus remote_write endpoint, for environments where nothing
+// can scrape the exporter. The requests failing with a retryable error are kept in memory and sent again,
+// the oldest are dropped once maxPending requests are waiting.
+type remoteWriter struct {
+	url        string
+	client     *http.Client
+	interval   time.Duration
+	maxPending int
+	projects   *projectSet
+	leader     *leaderElector
+
+	mutex   sync.Mutex
+	pending []remoteWriteBatch // oldest first
+	batches uint64             // number of batches queued so far, numbering them
+}
+
+// remoteWriteBatch is an encoded and snappy compressed remote write request.
+type remoteWriteBatch struct {
+	id      uint64
+	body    []byte
+	samples int
+}
+
+// run collects and pushes the metrics every interval until ctx is done. Only the leader pushes,
+// so the replicas don't write the same series.
+func (w *remoteWriter) run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		if w.leader.leading() {
+			w.collect(ctx)
+		}
+		w.flush(ctx, time.Now().Add(w.interval))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// collect gathers the metrics of all projects and queues them to be sent.
+func (w *remoteWriter) collect(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, w.interval)
+	defer cancel()
+	families, err := gatherProjects(ctx, w.projects)
+	if err != nil {
+		// The families gathered without an error are pushed anyway.
+		log.Warnf("Couldn't gather all metrics for remote write: %v", err)
+	}
+	series := flattenFamilies(families, time.Now().UnixNano()/int64(time.Millisecond))
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for start := 0; start < len(series); start += maxSamplesPerSend {
+		end := start + maxSamplesPerSend
+		if end > len(series) {
+			end = len(series)
+		}
+		w.batches++
+		w.pending = append(w.pending, remoteWriteBatch{id: w.batches, body: encodeWriteRequest(series[start:end]), samples: end - start})
+	}
+	if dropped := len(w.pending) - w.maxPending; dropped > 0 {
+		log.Warnf("Dropping %d remote write requests, %d are pending already", dropped, w.maxPending)
+		pushDropped.WithLabelValues("remote_write").Add(float64(dropped))
+		w.pending = w.pending[dropped:]
+	}
+}
+
+// flush sends the pending requests in order. A retryable failure is retried with a doubling backoff
+// until deadline, the requests rejected by the endpoint are dropped as sending them again can't succeed.
+func (w *remoteWriter) flush(ctx context.Context, deadline time.Time) {
+	backoff := time.Second
+	for {
+		w.mutex.Lock()
+		if len(w.pending) == 0 {
+			w.mutex.Unlock()
+			return
+		}
+		batch := w.pending[0]
+		w.mutex.Unlock()
+
+		retry, err := w.send(ctx, batch.body)
+		if err == nil {
+			pushSamples.WithLabelValues("remote_write").Add(float64(batch.samples))
+			backoff = time.Second
+		} else {
+			pushFailures.WithLabelValues("remote_write").Inc()
+			log.Errorf("Remote write failed: %v", err)
+			if retry {
+				wait := time.Until(deadline)
+				if backoff < wait {
+					wait = backoff
+				}
+				if wait <= 0 {
+					return
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(wait):
+				}
+				backoff *= 2
+				continue
+			}
+			pushDropped.WithLabelValues("remote_write").Inc()
+		}
+
+		// collect may have dropped the batch meanwhile.
+		w.mutex.Lock()
+		if len(w.pending) > 0 && w.pending[0].id == batch.id {
+			w.pending = w.pending[1:]
+		}
+		w.mutex.Unlock()
+	}
+}
+
+// send posts a request to the endpoint and tells whether a failure is worth retrying.
+func (w *remoteWriter) send(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	err = fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	return resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests, err
+}
+
+// pendingBatches returns the number of requests waiting to be sent.
+func (w *remoteWriter) pendingBatches() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return len(w.pending)
+}
+
+// encodeWriteRequest returns the snappy compressed prometheus.WriteRequest protobuf of series. The
+// few messages involved are encoded by hand instead of pulling in the Prometheus code generated for them:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(series []flatSeries) []byte {
+	var request, ts, message []byte
+	for _, s := range series {
+		ts = ts[:0]
+		for _, l := range s.labels {
+			message = message[:0]
+			message = protowire.AppendTag(message, 1, protowire.BytesType)
+			message = protowire.AppendString(message, l.GetName())
+			message = protowire.AppendTag(message, 2, protowire.BytesType)
+			message = protowire.AppendString(message, l.GetValue())
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, message)
+		}
+		message = message[:0]
+		message = protowire.AppendTag(message, 1, protowire.Fixed64Type)
+		message = protowire.AppendFixed64(message, math.Float64bits(s.value))
+		message = protowire.AppendTag(message, 2, protowire.VarintType)
+		message = protowire.AppendVarint(message, uint64(s.timestamp))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, message)
+
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, ts)
+	}
+	return snappy.Encode(nil, request)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// reportOptions are the flags of the report subcommand.
+type reportOptions struct {
+	format   string
+	minRatio float64
+}
+
+// parseReportArgs parses the arguments following the report subcommand.
+func parseReportArgs(args []string) (*reportOptions, error) {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	o := &reportOptions{}
+	fs.StringVar(&o.format, "format", "csv", "Format of the report, csv or json.")
+	fs.Float64Var(&o.minRatio, "min-ratio", 0, "Only report the quotas whose usage/limit ratio is at least this value.")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected report arguments %v", fs.Args())
+	}
+	if o.format != "csv" && o.format != "json" {
+		return nil, fmt.Errorf("unknown report format %q, must be csv or json", o.format)
+	}
+	return o, nil
+}
+
+// reportRow is a quota of the capacity report.
+type reportRow struct {
+	Project string  `json:"project"`
+	Region  string  `json:"region"`
+	Quota   string  `json:"quota"`
+	Limit   float64 `json:"limit"` // negative when unlimited
+	Usage   float64 `json:"usage"`
+	Ratio   float64 `json:"ratio"` // 0 for the unlimited and zero limits
+}
+
+// capacityReport returns the last fetched quotas of all projects with a usage ratio of at least
+// minRatio, the most used first.
+func capacityReport(projects *projectSet, minRatio float64) []reportRow {
+	rows := []reportRow{}
+	_, exporters := projects.get()
+	for project, exporter := range exporters {
+		quotas, _, ok := exporter.latestQuotas()
+		if !ok {
+			continue
+		}
+		for _, q := range quotas {
+			row := reportRow{Project: project, Region: q.Region, Quota: q.Metric, Limit: q.Limit, Usage: q.Usage}
+			if q.Limit > 0 {
+				row.Ratio = q.Usage / q.Limit
+			}
+			if row.Ratio < minRatio {
+				continue
+			}
+			rows = append(rows, row)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.Ratio != b.Ratio {
+			return a.Ratio > b.Ratio
+		}
+		if a.Project != b.Project {
+			return a.Project < b.Project
+		}
+		if a.Region != b.Region {
+			return a.Region < b.Region
+		}
+		return a.Quota < b.Quota
+	})
+	return rows
+}
+
+// writeReport writes the rows to out as CSV with a header line or as a JSON array.
+func writeReport(out io.Writer, rows []reportRow, format string) error {
+	if format == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(rows)
+	}
+	w := csv.NewWriter(out)
+	w.Write([]string{"project", "region", "quota", "limit", "usage", "ratio"})
+	for _, row := range rows {
+		w.Write([]string{
+			row.Project,
+			row.Region,
+			row.Quota,
+			strconv.FormatFloat(row.Limit, 'f', -1, 64),
+			strconv.FormatFloat(row.Usage, 'f', -1, 64),
+			strconv.FormatFloat(row.Ratio, 'f', 4, 64),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
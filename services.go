@@ -0,0 +1,118 @@
+package main
+
+import "strings"
+
+// quotaCategories maps Compute API quota metrics to the category label exported with them.
+var quotaCategories = map[string]string{
+	"AFFINITY_GROUPS":                     "compute",
+	"AUTOSCALERS":                         "compute",
+	"COMMITMENTS":                         "compute",
+	"CPUS":                                "compute",
+	"INSTANCES":                           "compute",
+	"INSTANCE_GROUPS":                     "compute",
+	"INSTANCE_GROUP_MANAGERS":             "compute",
+	"INSTANCE_TEMPLATES":                  "compute",
+	"MACHINE_IMAGES":                      "compute",
+	"NODE_GROUPS":                         "compute",
+	"NODE_TEMPLATES":                      "compute",
+	"REGIONAL_AUTOSCALERS":                "compute",
+	"REGIONAL_INSTANCE_GROUP_MANAGERS":    "compute",
+	"RESERVATIONS":                        "compute",
+	"RESOURCE_POLICIES":                   "compute",
+	"IMAGES":                              "storage",
+	"SNAPSHOTS":                           "storage",
+	"FIREWALLS":                           "networking",
+	"GLOBAL_INTERNAL_ADDRESSES":           "networking",
+	"IN_USE_ADDRESSES":                    "networking",
+	"IN_USE_BACKUP_SCHEDULES":             "storage",
+	"IN_USE_SNAPSHOT_SCHEDULES":           "storage",
+	"NETWORKS":                            "networking",
+	"NETWORK_ENDPOINT_GROUPS":             "networking",
+	"NETWORK_FIREWALL_POLICIES":           "networking",
+	"PACKET_MIRRORINGS":                   "networking",
+	"PUBLIC_ADVERTISED_PREFIXES":          "networking",
+	"PUBLIC_DELEGATED_PREFIXES":           "networking",
+	"ROUTERS":                             "networking",
+	"ROUTES":                              "networking",
+	"STATIC_ADDRESSES":                    "networking",
+	"STATIC_BYOIP_ADDRESSES":              "networking",
+	"SUBNETWORKS":                         "networking",
+	"XPN_SERVICE_PROJECTS":                "networking",
+	"BACKEND_BUCKETS":                     "loadbalancing",
+	"BACKEND_SERVICES":                    "loadbalancing",
+	"FORWARDING_RULES":                    "loadbalancing",
+	"HEALTH_CHECKS":                       "loadbalancing",
+	"SSL_CERTIFICATES":                    "loadbalancing",
+	"SSL_POLICIES":                        "loadbalancing",
+	"TARGET_HTTP_PROXIES":                 "loadbalancing",
+	"TARGET_HTTPS_PROXIES":                "loadbalancing",
+	"TARGET_INSTANCES":                    "loadbalancing",
+	"TARGET_POOLS":                        "loadbalancing",
+	"TARGET_SSL_PROXIES":                  "loadbalancing",
+	"TARGET_TCP_PROXIES":                  "loadbalancing",
+	"URL_MAPS":                            "loadbalancing",
+	"EXTERNAL_VPN_GATEWAYS":               "hybrid-connectivity",
+	"INTERCONNECTS":                       "hybrid-connectivity",
+	"INTERCONNECT_ATTACHMENTS_PER_REGION": "hybrid-connectivity",
+	"INTERCONNECT_ATTACHMENTS_TOTAL_MBPS": "hybrid-connectivity",
+	"TARGET_VPN_GATEWAYS":                 "hybrid-connectivity",
+	"VPN_GATEWAYS":                        "hybrid-connectivity",
+	"VPN_TUNNELS":                         "hybrid-connectivity",
+	"SECURITY_POLICIES":                   "security",
+	"SECURITY_POLICY_CEVAL_RULES":         "security",
+	"SECURITY_POLICY_RULES":               "security",
+}
+
+// quotaCategoryRules categorise the quota metrics missing from quotaCategories, first match wins.
+var quotaCategoryRules = []struct {
+	match    func(metric, pattern string) bool
+	pattern  string
+	category string
+}{
+	{strings.HasSuffix, "_GPUS", "compute"},
+	{strings.HasSuffix, "_CPUS", "compute"},
+	{strings.HasSuffix, "_TPUS", "compute"},
+	{strings.HasSuffix, "_GB", "storage"},
+	{strings.Contains, "IOPS", "storage"},
+	{strings.Contains, "THROUGHPUT", "storage"},
+	{strings.Contains, "FORWARDING_RULES", "loadbalancing"},
+	{strings.Contains, "BACKEND_SERVICES", "loadbalancing"},
+	{strings.Contains, "TARGET_", "loadbalancing"},
+	{strings.Contains, "INTERCONNECT", "hybrid-connectivity"},
+	{strings.Contains, "VPN", "hybrid-connectivity"},
+	{strings.Contains, "ADDRESSES", "networking"},
+	{strings.Contains, "NETWORK", "networking"},
+	{strings.Contains, "SECURITY_POLIC", "security"},
+	{strings.Contains, "OS_LOGIN", "os-login"},
+}
+
+// quotaCategory returns the category a Compute API quota metric belongs to.
+func quotaCategory(metric string) string {
+	if category, ok := quotaCategories[metric]; ok {
+		return category
+	}
+	for _, rule := range quotaCategoryRules {
+		if rule.match(metric, rule.pattern) {
+			return rule.category
+		}
+	}
+	return "other"
+}
+
+// quotaUnit returns the unit of a Compute API quota metric value.
+func quotaUnit(metric string) string {
+	switch {
+	case strings.HasSuffix(metric, "_GB"):
+		return "GB"
+	case strings.HasSuffix(metric, "_MBPS"):
+		return "Mbps"
+	case strings.Contains(metric, "IOPS"):
+		return "iops"
+	case strings.Contains(metric, "THROUGHPUT"):
+		return "MBps"
+	}
+	return "count"
+}
+
+// gigabyte is the size of the GB unit used by the Compute API storage quotas, which is binary.
+const gigabyte = 1 << 30
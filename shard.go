@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// shard is the part of the configured projects scraped by one of several exporter replicas.
+// Projects are assigned by the hash of their ID, so every replica computes the same partition.
+type shard struct {
+	index int
+	total int
+}
+
+// newShard validates the shard flags. An index of -1 is taken from the ordinal at the
+// end of the hostname, e.g. gcp-quota-exporter-2 of a StatefulSet is shard 2.
+func newShard(index, total int) (shard, error) {
+	if total < 1 {
+		return shard{}, fmt.Errorf("invalid shard total %d, must be at least 1", total)
+	}
+	if index == -1 {
+		if total == 1 {
+			return shard{index: 0, total: 1}, nil
+		}
+		hostname, err := os.Hostname()
+		if err != nil {
+			return shard{}, err
+		}
+		ordinal, err := strconv.Atoi(hostname[strings.LastIndex(hostname, "-")+1:])
+		if err != nil {
+			return shard{}, fmt.Errorf("no StatefulSet ordinal in hostname %q", hostname)
+		}
+		index = ordinal
+	}
+	if index < 0 || index >= total {
+		return shard{}, fmt.Errorf("invalid shard index %d, must be between 0 and %d", index, total-1)
+	}
+	return shard{index: index, total: total}, nil
+}
+
+// owns tells whether project is scraped by this shard.
+func (s shard) owns(project string) bool {
+	if s.total <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(project))
+	return int(h.Sum32()%uint32(s.total)) == s.index
+}
+
+// filter returns the projects scraped by this shard.
+func (s shard) filter(projects []gcpQuota) []gcpQuota {
+	if s.total <= 1 {
+		return projects
+	}
+	var owned []gcpQuota
+	for _, project := range projects {
+		if s.owns(project.Project) {
+			owned = append(owned, project)
+		}
+	}
+	return owned
+}
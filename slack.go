@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// slackPostMessageURL is the Web API method posting a message with a bot token.
+const slackPostMessageURL = "https://slack.com/api/chat.postMessage"
+
+// defaultSlackTemplate is the message of a threshold crossing, in Slack's mrkdwn.
+const defaultSlackTemplate = `{{ if eq .Type "threshold_crossed" }}{{ if eq .Severity "critical" }}:fire:{{ else }}:rotating_light:{{ end }} GCP quota *{{ .Metric }}* over {{ percent .Threshold }}{{ else }}:white_check_mark: GCP quota *{{ .Metric }}* back under {{ percent .Threshold }}{{ end }}
+Project: *{{ .Project }}*, region: *{{ or .Region "global" }}*
+Usage: {{ number .Usage }} of {{ number .Limit }}{{ if ne .Unit "count" }} {{ .Unit }}{{ end }} ({{ percent .Ratio }})`
+
+// slackNotifier posts the threshold crossings to Slack, with an incoming webhook or with a bot token to the
+// channel of the project. The message is a Go template over the quotaEvent with the templateFuncs.
+type slackNotifier struct {
+	webhookFile string // file holding the incoming webhook URL, empty with a bot token
+	tokenFile   string // file holding the bot token, read on every message so it can be rotated
+	channel     string // channel of the projects without a slack_channel
+	template    *template.Template
+	client      *http.Client
+}
+
+// newSlackNotifier returns a notifier posting with the incoming webhook URL of webhookFile or the bot token of
+// tokenFile, exactly one of them is set, and the message of the template file, defaultSlackTemplate when it is empty.
+func newSlackNotifier(webhookFile, tokenFile, channel, templateFile string, timeout time.Duration) (*slackNotifier, error) {
+	if (webhookFile == "") == (tokenFile == "") {
+		return nil, fmt.Errorf("exactly one of the Slack webhook URL file and token file must be set")
+	}
+	if tokenFile != "" && channel == "" {
+		return nil, fmt.Errorf("the default Slack channel must be set with a bot token")
+	}
+	for _, file := range []string{webhookFile, tokenFile} {
+		if file != "" {
+			if _, err := readSecret(file); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	text := defaultSlackTemplate
+	if templateFile != "" {
+		content, err := ioutil.ReadFile(templateFile)
+		if err != nil {
+			return nil, err
+		}
+		text = string(content)
+	}
+	tmpl, err := template.New("slack").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	// The template is executed for a sample event, so a broken template fails at startup.
+	if err := tmpl.Execute(ioutil.Discard, quotaEvent{Type: eventThresholdCrossed, Time: time.Now()}); err != nil {
+		return nil, err
+	}
+	return &slackNotifier{
+		webhookFile: webhookFile,
+		tokenFile:   tokenFile,
+		channel:     channel,
+		template:    tmpl,
+		client:      &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// deliver posts a message per event.
+func (n *slackNotifier) deliver(ctx context.Context, events []quotaEvent) (int, error) {
+	for i, event := range events {
+		if err := n.send(ctx, event); err != nil {
+			return i, err
+		}
+	}
+	return len(events), nil
+}
+
+// slackTransientErrors are the errors of the Web API worth retrying, the other ones fail again on every retry.
+var slackTransientErrors = map[string]bool{
+	"ratelimited":         true,
+	"internal_error":      true,
+	"fatal_error":         true,
+	"service_unavailable": true,
+	"request_timeout":     true,
+}
+
+// send posts the message of event to the channel of its project. Only the network errors, the timeouts, the
+// throttling and the server errors are retried, the event is rejected on any other failure, e.g. an unknown
+// channel or a revoked webhook.
+func (n *slackNotifier) send(ctx context.Context, event quotaEvent) error {
+	var text bytes.Buffer
+	if err := n.template.Execute(&text, event); err != nil {
+		return &rejectedError{err}
+	}
+	channel := event.config.SlackChannel
+	if channel == "" {
+		channel = n.channel
+	}
+	// Incoming webhooks post to their own channel, the channel only applies to the legacy ones.
+	message := map[string]interface{}{"text": text.String()}
+	if channel != "" {
+		message["channel"] = channel
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	target, authorization := slackPostMessageURL, ""
+	if n.webhookFile != "" {
+		if target, err = readSecret(n.webhookFile); err != nil {
+			return err
+		}
+	} else {
+		token, err := readSecret(n.tokenFile)
+		if err != nil {
+			return err
+		}
+		authorization = "Bearer " + token
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		// The error would show the secret webhook URL.
+		return fmt.Errorf("invalid Slack webhook URL in %s", n.webhookFile)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// The path of an incoming webhook URL is its secret.
+		if urlErr, ok := err.(*url.Error); ok && n.webhookFile != "" {
+			urlErr.URL = "Slack webhook"
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	content, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 {
+		err := fmt.Errorf("Slack returned %s: %s", resp.Status, strings.TrimSpace(string(content)))
+		switch {
+		case resp.StatusCode/100 == 5, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusRequestTimeout:
+			return err
+		}
+		return &rejectedError{err}
+	}
+	if n.webhookFile != "" {
+		return nil
+	}
+	// The Web API reports its failures in the body of a 200 response.
+	var result struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(content, &result); err != nil {
+		return fmt.Errorf("invalid Slack response: %v", err)
+	}
+	if !result.OK {
+		err := fmt.Errorf("Slack failed to post to %s: %s", channel, result.Error)
+		if slackTransientErrors[result.Error] {
+			return err
+		}
+		return &rejectedError{err}
+	}
+	return nil
+}
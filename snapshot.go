@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/compute/v1"
+)
+
+// snapshotVersion is the format of the snapshot file, snapshots of another version are ignored.
+const snapshotVersion = 1
+
+// snapshot is the last fetched quota data of every project, written to disk so a restarted
+// exporter serves it until it fetched fresh data.
+type snapshot struct {
+	Version  int                        `json:"version"`
+	Projects map[string]snapshotProject `json:"projects"`
+}
+
+type snapshotProject struct {
+	Time         time.Time          `json:"time"`
+	Project      *compute.Project   `json:"project,omitempty"`
+	Regions      []*compute.Region  `json:"regions,omitempty"`
+	RegionErrors map[string]string  `json:"regionErrors,omitempty"`
+	RegionsTotal int                `json:"regionsTotal"`
+	Networks     []*compute.Network `json:"networks"`
+}
+
+// readSnapshot returns the results stored in the snapshot at path by project, nil when there is no snapshot yet.
+func readSnapshot(path string) (map[string]*scrapeResult, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s snapshot
+	if err := json.Unmarshal(content, &s); err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s: %v", path, err)
+	}
+	if s.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d in %s", s.Version, path)
+	}
+	results := make(map[string]*scrapeResult, len(s.Projects))
+	for project, p := range s.Projects {
+		results[project] = &scrapeResult{
+			time:         p.Time,
+			project:      p.Project,
+			regions:      p.Regions,
+			regionErrors: p.RegionErrors,
+			regionsTotal: p.RegionsTotal,
+			networks:     p.Networks,
+		}
+	}
+	return results, nil
+}
+
+// writeSnapshot stores the last fetched data of exporters at path. The file is replaced
+// atomically, so a crash while writing leaves the previous snapshot in place.
+func writeSnapshot(path string, exporters map[string]*Exporter) error {
+	s := snapshot{Version: snapshotVersion, Projects: make(map[string]snapshotProject, len(exporters))}
+	for project, exporter := range exporters {
+		exporter.statusMutex.RLock()
+		res := exporter.latest
+		exporter.statusMutex.RUnlock()
+		if res == nil {
+			continue
+		}
+		s.Projects[project] = snapshotProject{
+			Time:         res.time,
+			Project:      res.project,
+			Regions:      res.regions,
+			RegionErrors: res.regionErrors,
+			RegionsTotal: res.regionsTotal,
+			Networks:     res.networks,
+		}
+	}
+	content, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, content, 0600)
+}
+
+// writeFileAtomic writes content to a temporary file next to path and renames it to path, so readers never see
+// a partly written file. The temporary file ends in .tmp, so it doesn't match the pattern of the final file.
+func writeFileAtomic(path string, content []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// restore serves res, read from a snapshot, until the exporter fetched data of its own.
+func (e *Exporter) restore(res *scrapeResult) {
+	e.mutex.Lock()
+	if e.cached == nil {
+		e.cached = res
+	}
+	e.mutex.Unlock()
+
+	e.statusMutex.Lock()
+	if e.latest == nil {
+		e.latest = res
+	}
+	e.statusMutex.Unlock()
+	log.Infof("Restored quota data of %s fetched at %s", e.project, res.time.Format(time.RFC3339))
+}
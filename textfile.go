@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+	log "github.com/sirupsen/logrus"
+)
+
+// textfileExcluded are the prefixes of the metrics not written to the textfile, as node_exporter
+// exposes metrics of its own process with the same names and would fail on the duplicates.
+var textfileExcluded = []string{"go_", "process_", "promhttp_"}
+
+// textfileWriter writes the metrics in the text format to a .prom file for the textfile collector of
+// node_exporter, so hosts running it pick up the quota data without another port to scrape.
+type textfileWriter struct {
+	path     string
+	projects *projectSet
+}
+
+// run writes the file every interval until ctx is done.
+func (w *textfileWriter) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		writeCtx, cancel := context.WithTimeout(ctx, interval)
+		w.write(writeCtx)
+		cancel()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// write collects the metrics of all projects and replaces the file with them.
+func (w *textfileWriter) write(ctx context.Context) error {
+	families, err := gatherProjects(ctx, w.projects)
+	if err != nil {
+		// The families gathered without an error are written anyway.
+		log.Warnf("Couldn't gather all metrics for the textfile: %v", err)
+	}
+	var content bytes.Buffer
+	encoder := expfmt.NewEncoder(&content, expfmt.FmtText)
+	samples := 0
+	for _, family := range families {
+		if textfileExcludes(family.GetName()) {
+			continue
+		}
+		if err := encoder.Encode(family); err != nil {
+			log.Errorf("Couldn't encode %s for the textfile: %v", family.GetName(), err)
+			continue
+		}
+		samples += len(family.GetMetric())
+	}
+	// node_exporter runs as another user, the file has to be readable by it.
+	if err := writeFileAtomic(w.path, content.Bytes(), 0644); err != nil {
+		pushFailures.WithLabelValues("textfile").Inc()
+		log.Errorf("Couldn't write the textfile: %v", err)
+		return err
+	}
+	pushSamples.WithLabelValues("textfile").Add(float64(samples))
+	return nil
+}
+
+func textfileExcludes(name string) bool {
+	for _, prefix := range textfileExcluded {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// proxyDirect as the proxy of a project calls the Google APIs without a proxy.
+const proxyDirect = "direct"
+
+// transportOptions tune the HTTP transport shared by the Google API clients of all exporters.
+type transportOptions struct {
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+	keepAlive           time.Duration
+	dialTimeout         time.Duration
+	tlsHandshakeTimeout time.Duration
+	http2               bool
+	proxy               string // proxy URL of all calls, empty for the proxy environment variables
+}
+
+// newTransport returns a transport like http.DefaultTransport with the settings of o.
+func newTransport(o transportOptions) *http.Transport {
+	dialer := &net.Dialer{Timeout: o.dialTimeout, KeepAlive: o.keepAlive}
+	t := &http.Transport{
+		Proxy:                 proxyFunc(o.proxy),
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     o.http2,
+		MaxIdleConnsPerHost:   o.maxIdleConnsPerHost,
+		IdleConnTimeout:       o.idleConnTimeout,
+		TLSHandshakeTimeout:   o.tlsHandshakeTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+	if !o.http2 {
+		// A non-nil empty map keeps the transport from upgrading TLS connections to HTTP/2.
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	return t
+}
+
+// parseHeaders parses a comma separated list of name=value headers.
+func parseHeaders(list string) (map[string]string, error) {
+	headers := map[string]string{}
+	for _, header := range strings.Split(list, ",") {
+		if strings.TrimSpace(header) == "" {
+			continue
+		}
+		parts := strings.SplitN(header, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid header %q, must be name=value", header)
+		}
+		headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return headers, nil
+}
+
+// headerTransport adds a User-Agent and headers to the Google API calls, for the attribution
+// of the calls by the cloud governance tooling.
+type headerTransport struct {
+	base      http.RoundTripper
+	userAgent string // put in front of the User-Agent of the clients
+	headers   map[string]string
+}
+
+// withHeaders returns base adding the User-Agent and the headers of the comma separated name=value list,
+// base itself when there are none.
+func withHeaders(base http.RoundTripper, userAgent, headers string) (http.RoundTripper, error) {
+	parsed, err := parseHeaders(headers)
+	if err != nil {
+		return nil, err
+	}
+	if userAgent == "" && len(parsed) == 0 {
+		return base, nil
+	}
+	return headerTransport{base: base, userAgent: userAgent, headers: parsed}, nil
+}
+
+func (t headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, value := range t.headers {
+		req.Header.Set(name, value)
+	}
+	if t.userAgent != "" {
+		// The User-Agent of the clients is kept, as the Google APIs only compress the responses to clients with gzip in it.
+		req.Header.Set("User-Agent", strings.TrimSpace(t.userAgent+" "+req.Header.Get("User-Agent")))
+	}
+	return t.base.RoundTrip(req)
+}
+
+// proxyFunc returns the proxy selection of a transport: the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment
+// variables when proxy is empty, else proxy for every host but those in NO_PROXY, and no proxy at all for direct.
+// The user info of the proxy URL authenticates to the proxy.
+func proxyFunc(proxy string) func(*http.Request) (*url.URL, error) {
+	switch proxy {
+	case "":
+		return http.ProxyFromEnvironment
+	case proxyDirect:
+		return nil
+	}
+	config := httpproxy.FromEnvironment()
+	config.HTTPProxy, config.HTTPSProxy = proxy, proxy
+	selectProxy := config.ProxyFunc()
+	return func(r *http.Request) (*url.URL, error) {
+		return selectProxy(r.URL)
+	}
+}
+
+// withProxy returns a copy of base using proxy, base itself when proxy is empty. The copy has a connection pool of its own.
+func withProxy(base http.RoundTripper, proxy string) http.RoundTripper {
+	if proxy == "" {
+		return base
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t, ok := base.(*http.Transport)
+	if !ok {
+		return base
+	}
+	t = t.Clone()
+	t.Proxy = proxyFunc(proxy)
+	return t
+}
+
+// checkProxy fails for a proxy which isn't empty, direct or an http, https or socks5 URL.
+func checkProxy(proxy string) error {
+	if proxy == "" || proxy == proxyDirect {
+		return nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return fmt.Errorf("invalid proxy URL: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") || u.Host == "" {
+		return fmt.Errorf("proxy %q must be direct or an http, https or socks5 URL", u.Redacted())
+	}
+	return nil
+}
@@ -0,0 +1,675 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// instrumentHandler tracks the in-flight requests and request durations of h under the handler label name.
+func instrumentHandler(name string, h http.Handler) http.Handler {
+	return promhttp.InstrumentHandlerInFlight(httpInFlight.WithLabelValues(name),
+		promhttp.InstrumentHandlerDuration(httpDuration.MustCurryWith(prometheus.Labels{"handler": name}), h),
+	)
+}
+
+// statusRecorder keeps the status code written through a ResponseWriter.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// requestIDHandler attaches a request ID to the context of every request and to the response.
+// The X-Request-Id header of the client is reused when set, e.g. by a proxy in front of the exporter.
+func requestIDHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		h.ServeHTTP(w, r.WithContext(withRequestID(r.Context(), id)))
+	})
+}
+
+// newRequestID returns a random request ID.
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// accessLogHandler logs every request served by h.
+func accessLogHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(recorder, r)
+		contextLogger(r.Context()).WithFields(log.Fields{
+			"method":      r.Method,
+			"path":        r.URL.Path,
+			"status":      recorder.status,
+			"duration":    time.Since(start).Seconds(),
+			"remote_addr": r.RemoteAddr,
+		}).Info("Request served")
+	})
+}
+
+// parseCIDRs parses a comma separated list of networks.
+func parseCIDRs(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range strings.Split(list, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, network)
+	}
+	return nets, nil
+}
+
+// Formats of the project IDs, optionally domain-scoped, and of the regions probed.
+var (
+	projectIDPattern = regexp.MustCompile(`^([a-z0-9][a-z0-9.-]*[a-z0-9]:)?[a-z][a-z0-9-]{4,28}[a-z0-9]$`)
+	regionPattern    = regexp.MustCompile(`^[a-z]+(-[a-z]+)+[0-9]+$`)
+)
+
+// parseProjects parses a comma separated list of project IDs.
+func parseProjects(list string) (map[string]bool, error) {
+	projects := make(map[string]bool)
+	for _, project := range strings.Split(list, ",") {
+		project = strings.TrimSpace(project)
+		if project == "" {
+			continue
+		}
+		if !projectIDPattern.MatchString(project) {
+			return nil, fmt.Errorf("invalid project ID %q", project)
+		}
+		projects[project] = true
+	}
+	return projects, nil
+}
+
+// allowlistHandler refuses the requests of clients outside nets. Forwarding headers
+// aren't trusted, the address of the connection is checked.
+func allowlistHandler(nets []*net.IPNet, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			for _, network := range nets {
+				if network.Contains(ip) {
+					h.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	})
+}
+
+// corsHandler adds the CORS headers allowing the browsers of origins to read the responses of h,
+// "*" allows any origin. Preflight requests are answered directly.
+func corsHandler(origins []string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		allowed := false
+		for _, o := range origins {
+			if o == "*" || o == origin {
+				allowed = origin != ""
+				break
+			}
+		}
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+				if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+					w.Header().Set("Access-Control-Allow-Headers", headers)
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// contextCollector binds an Exporter to the context of a single scrape.
+type contextCollector struct {
+	ctx      context.Context
+	exporter *Exporter
+}
+
+func (c contextCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c contextCollector) Collect(ch chan<- prometheus.Metric) {
+	// Projects served without a fetch don't hold up the ones after them.
+	defer passTurn(c.ctx)
+	c.exporter.collect(c.ctx, ch)
+}
+
+// infoCollector binds the metricInfoExporter to the context of a single scrape.
+type infoCollector struct {
+	ctx      context.Context
+	exporter *metricInfoExporter
+}
+
+func (c infoCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c infoCollector) Collect(ch chan<- prometheus.Metric) {
+	c.exporter.collect(c.ctx, ch)
+}
+
+// metricsHandler serves the project metrics. Each request gets its own registry so
+// the Google API calls of the scrape can be bound to the scrape timeout of Prometheus.
+type metricsHandler struct {
+	projects      *projectSet
+	timeoutOffset time.Duration
+	timeoutRatio  float64 // fraction of the Prometheus scrape timeout, 0 for none
+	maxTimeout    time.Duration
+	// handlerOpts controls the OpenMetrics negotiation and compression of the responses.
+	handlerOpts promhttp.HandlerOpts
+	// inFlight holds a token per running collection when their number is limited.
+	inFlight chan struct{}
+	// rounds counts the scrapes of all projects, to rotate the order they are fetched in.
+	rounds uint32
+	// leader gets the scrapes of the standby replicas forwarded, nil when every replica scrapes.
+	leader *leaderElector
+}
+
+// retryAfter is announced to the scrapers turned away because of the collection limit.
+const retryAfter = 5 * time.Second
+
+// scrapeContext returns the context bounding the Google API calls of a scrape. It is derived from the request
+// context, so the calls are cancelled when the scraper goes away. The timeout query parameter, capped at
+// maxTimeout, takes precedence. Otherwise it expires timeoutOffset before the timeout Prometheus announces
+// in the X-Prometheus-Scrape-Timeout-Seconds header, cut to timeoutRatio of it when set.
+func (h *metricsHandler) scrapeContext(r *http.Request) (context.Context, context.CancelFunc, error) {
+	var timeout time.Duration
+	if param := r.URL.Query().Get("timeout"); param != "" {
+		var err error
+		timeout, err = time.ParseDuration(param)
+		if err != nil || timeout <= 0 {
+			return nil, nil, fmt.Errorf("invalid timeout %q", param)
+		}
+		if h.maxTimeout > 0 && timeout > h.maxTimeout {
+			timeout = h.maxTimeout
+		}
+	} else if header := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); header != "" {
+		seconds, err := strconv.ParseFloat(header, 64)
+		if err != nil {
+			contextLogger(r.Context()).Warnf("Invalid X-Prometheus-Scrape-Timeout-Seconds header %q: %v", header, err)
+		} else {
+			timeout = time.Duration(seconds*float64(time.Second)) - h.timeoutOffset
+			if timeout <= 0 {
+				timeout = time.Duration(seconds * float64(time.Second))
+			}
+			if budget := time.Duration(seconds * h.timeoutRatio * float64(time.Second)); budget > 0 && budget < timeout {
+				timeout = budget
+			}
+		}
+	}
+
+	ctx := r.Context()
+	if timeout <= 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, cancel, nil
+}
+
+// serve gathers the exporters, along with the exporter's own metrics when own is set, and writes the result
+// to w. Standby replicas forward the scrape to the leader instead.
+func (h *metricsHandler) serve(w http.ResponseWriter, r *http.Request, own bool, exporters ...*Exporter) {
+	if h.leader.proxy(w, r) {
+		return
+	}
+	ctx, cancel, err := h.scrapeContext(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer cancel()
+
+	if h.inFlight != nil {
+		select {
+		case h.inFlight <- struct{}{}:
+			defer func() { <-h.inFlight }()
+		default:
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+			http.Error(w, "too many concurrent scrapes", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	gatherers := prometheus.Gatherers{projectRegistry(ctx, exporters...)}
+	if own {
+		gatherers = append(gatherers, h.projects.ownGatherers(ctx)...)
+	}
+	promhttp.HandlerFor(gatherers, h.handlerOpts).ServeHTTP(w, r)
+}
+
+// projectRegistry returns a registry collecting exporters bound to ctx, taking their turns in the given order.
+func projectRegistry(ctx context.Context, exporters ...*Exporter) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	order := newScrapeOrder(len(exporters))
+	for i, exporter := range exporters {
+		registry.MustRegister(contextCollector{ctx: withScrapeTurn(ctx, scrapeTurn{order: order, index: i}), exporter: exporter})
+	}
+	return registry
+}
+
+// ownGatherers returns the exporter's own metrics along with gcp_quota_metric_info, whose Service Usage
+// call is bound to ctx.
+func (s *projectSet) ownGatherers(ctx context.Context) prometheus.Gatherers {
+	gatherers := prometheus.Gatherers{prometheus.DefaultGatherer}
+	if info := s.metricInfoExporter(); info != nil {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(infoCollector{ctx: ctx, exporter: info})
+		gatherers = append(gatherers, registry)
+	}
+	return gatherers
+}
+
+// ServeHTTP serves the metrics of all projects along with the exporter's own metrics.
+func (h *metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, all := h.projects.get()
+	exporters := make([]*Exporter, 0, len(all))
+	for _, exporter := range all {
+		exporters = append(exporters, exporter)
+	}
+	exporters = rotateExporters(exporters, atomic.AddUint32(&h.rounds, 1))
+	h.serve(w, r, true, exporters...)
+}
+
+// projectHandler serves <prefix><project> with only that project's metrics,
+// so tenants sharing the exporter can scrape their own project.
+func (h *metricsHandler) projectHandler(prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, exporters := h.projects.get()
+		exporter, ok := exporters[strings.TrimPrefix(r.URL.Path, prefix)]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		h.serve(w, r, false, exporter)
+	})
+}
+
+// probeHandler scrapes the project, and optionally only one of its regions, given in the query
+// on demand, blackbox exporter style. Configured projects are probed with their own credentials,
+// the other projects of allowed with probeCredentials or the Application Default Credentials. Any
+// other project is refused, as every probed project adds its series and Google API calls.
+func (h *metricsHandler) probeHandler(opts exporterOptions, probeCredentials string, allowed map[string]bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		project := r.URL.Query().Get("project")
+		if project == "" {
+			http.Error(w, "project parameter is missing", http.StatusBadRequest)
+			return
+		}
+		region := r.URL.Query().Get("region")
+		if region != "" && !regionPattern.MatchString(region) {
+			http.Error(w, "invalid region parameter", http.StatusBadRequest)
+			return
+		}
+
+		_, exporters := h.projects.get()
+		exporter, ok := exporters[project]
+		if !ok && !projectIDPattern.MatchString(project) {
+			http.Error(w, "invalid project parameter", http.StatusBadRequest)
+			return
+		}
+		if !ok && !allowed[project] {
+			http.Error(w, "project "+project+" is neither configured nor in -probe.allowed-projects", http.StatusForbidden)
+			return
+		}
+		if !ok || region != "" {
+			// A configured project keeps its whole config, profile and thresholds included, only its regions change.
+			target := gcpQuota{Project: project, Credentials: probeCredentials}
+			if ok {
+				target = exporter.config()
+			}
+			if region != "" {
+				target.Regions = []string{region}
+			}
+			// Probes are one-off, they can't wait for a background scrape.
+			probeOpts := opts
+			probeOpts.interval = 0
+			// A probe of a single region would reset the alert state of the project's other regions.
+			probeOpts.alerts = nil
+			var err error
+			exporter, err = NewExporter(target, probeOpts)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := exporter.waitInit(r.Context()); err != nil {
+			http.Error(w, "invalid credentials for "+project+": "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		h.serve(w, r, false, exporter)
+	})
+}
+
+// sdTarget is a target group of the Prometheus HTTP service discovery.
+type sdTarget struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// sdHandler serves the /probe targets of the configured projects in the Prometheus http_sd format, a target
+// per region: the configured regions of the project, else the regions of its last scrape. A project without
+// known regions gets a single target probing all of it. The targets are the address the discovery was
+// requested at, so Prometheus probes the exporter serving it.
+func sdHandler(projects *projectSet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, exporters := projects.get()
+		names := make([]string, 0, len(exporters))
+		for project := range exporters {
+			names = append(names, project)
+		}
+		sort.Strings(names)
+
+		targets := []sdTarget{}
+		for _, project := range names {
+			exporter := exporters[project]
+			regions := exporter.regions
+			if len(regions) == 0 && exporter.scrapeProfile() != profileLight {
+				exporter.statusMutex.RLock()
+				if exporter.latest != nil {
+					for _, region := range exporter.latest.regions {
+						regions = append(regions, region.Name)
+					}
+				}
+				exporter.statusMutex.RUnlock()
+			}
+			if len(regions) == 0 {
+				targets = append(targets, sdTarget{Targets: []string{r.Host}, Labels: map[string]string{
+					"__metrics_path__": "/probe",
+					"__param_project":  project,
+					"instance":         project,
+				}})
+				continue
+			}
+			for _, region := range regions {
+				targets = append(targets, sdTarget{Targets: []string{r.Host}, Labels: map[string]string{
+					"__metrics_path__": "/probe",
+					"__param_project":  project,
+					"__param_region":   region,
+					"instance":         project + "/" + region,
+				}})
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(targets); err != nil {
+			log.Errorf("Couldn't encode service discovery targets: %v", err)
+		}
+	})
+}
+
+// healthHandler reports the process as alive.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("OK\n"))
+}
+
+// readyHandler reports ready once the config is loaded, the credentials of all projects are loaded,
+// when requireScrape is set a project was scraped successfully, and the first scrape of fraction
+// of the projects completed. The projects whose credentials failed to load are listed in the body
+// and left out of the fraction, so a single broken credential doesn't keep the exporter unready.
+func readyHandler(projects *projectSet, requireScrape bool, fraction float64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, exporters := projects.get()
+		scraped := !requireScrape
+		completed, total := 0, 0
+		var failed []string
+		for project, exporter := range exporters {
+			select {
+			case <-exporter.initDone:
+			default:
+				http.Error(w, "credentials of "+project+" not loaded", http.StatusServiceUnavailable)
+				return
+			}
+			if exporter.initErr != nil {
+				failed = append(failed, fmt.Sprintf("credentials of %s failed to load: %v", project, exporter.initErr))
+				continue
+			}
+			total++
+			scraped = scraped || exporter.ready(true)
+			if exporter.firstScrapeCompleted() {
+				completed++
+			}
+		}
+		if !scraped {
+			http.Error(w, "no project scraped yet", http.StatusServiceUnavailable)
+			return
+		}
+		if float64(completed) < fraction*float64(total) {
+			http.Error(w, fmt.Sprintf("%d of %d projects scraped", completed, total), http.StatusServiceUnavailable)
+			return
+		}
+		sort.Strings(failed)
+		w.Write([]byte("OK\n"))
+		for _, line := range failed {
+			fmt.Fprintln(w, line)
+		}
+	})
+}
+
+var landingTemplate = template.Must(template.New("landing").Parse(`<html>
+<head><title>GCP Quota Exporter</title></head>
+<body>
+<h1>GCP Quota Exporter</h1>
+<ul>
+<li><a href="{{.MetricsPath}}">Metrics</a></li>
+<li><a href="/dashboard">Dashboard</a></li>
+<li><a href="/healthz">Health</a></li>
+<li><a href="/readyz">Readiness</a></li>
+<li><a href="/config">Config</a></li>
+<li><a href="/api/v1/status">Status API</a></li>
+<li><a href="/api/v1/quotas">Quotas API</a></li>
+</ul>
+<h2>Projects</h2>
+<table border="1" cellpadding="4">
+<tr><th>Project</th><th>Last scrape</th><th>Duration</th><th>Status</th><th>Regions</th></tr>
+{{range .Projects}}<tr>
+<td>{{.Name}}</td>
+{{if .Status.Time.IsZero}}<td colspan="4">not scraped yet</td>{{else}}<td>{{.Status.Time.Format "2006-01-02 15:04:05 MST"}}</td>
+<td>{{.Status.Duration}}</td>
+<td>{{if .Status.Up}}up{{else}}down{{end}}</td>
+<td>{{.Status.RegionsScraped}}</td>{{end}}
+</tr>{{end}}
+</table>
+</body>
+</html>
+`))
+
+// landingHandler serves the landing page at / listing the configured projects with their last scrape status.
+func landingHandler(projects *projectSet, metricsPath string) http.Handler {
+	type project struct {
+		Name   string
+		Status scrapeStatus
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		data := struct {
+			MetricsPath string
+			Projects    []project
+		}{MetricsPath: metricsPath}
+		_, exporters := projects.get()
+		for name, exporter := range exporters {
+			data.Projects = append(data.Projects, project{Name: name, Status: exporter.lastStatus()})
+		}
+		sort.Slice(data.Projects, func(i, j int) bool { return data.Projects[i].Name < data.Projects[j].Name })
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := landingTemplate.Execute(w, data); err != nil {
+			log.Errorf("Couldn't render landing page: %v", err)
+		}
+	})
+}
+
+// configHandler serves the loaded project config as YAML.
+func configHandler(projects *projectSet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		loaded, _ := projects.get()
+		// The password of a proxy isn't shown.
+		config := make([]gcpQuota, len(loaded))
+		for i, project := range loaded {
+			config[i] = project
+			if u, err := url.Parse(project.ProxyURL); err == nil && project.ProxyURL != proxyDirect {
+				config[i].ProxyURL = u.Redacted()
+			}
+		}
+		content, err := yaml.Marshal(config)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/yaml; charset=utf-8")
+		w.Write(content)
+	})
+}
+
+// statusHandler serves the last scrape status of every project as JSON.
+func statusHandler(projects *projectSet) http.Handler {
+	type projectStatus struct {
+		Project string `json:"project"`
+		Ready   bool   `json:"ready"` // the project credentials are valid
+		scrapeStatus
+		DurationSeconds float64 `json:"duration_seconds"`
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, exporters := projects.get()
+		data := struct {
+			Projects []projectStatus `json:"projects"`
+		}{Projects: []projectStatus{}}
+		for name, exporter := range exporters {
+			status := exporter.lastStatus()
+			data.Projects = append(data.Projects, projectStatus{
+				Project:         name,
+				Ready:           exporter.ready(false),
+				scrapeStatus:    status,
+				DurationSeconds: status.Duration.Seconds(),
+			})
+		}
+		sort.Slice(data.Projects, func(i, j int) bool { return data.Projects[i].Project < data.Projects[j].Project })
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(data); err != nil {
+			log.Errorf("Couldn't encode status: %v", err)
+		}
+	})
+}
+
+// quotasHandler serves the quotas of the last successful fetch of every project, or of
+// the project given in the query, as JSON. The Compute API isn't called.
+func quotasHandler(projects *projectSet) http.Handler {
+	type projectQuotas struct {
+		Project string       `json:"project"`
+		Time    time.Time    `json:"time"`
+		Quotas  []quotaValue `json:"quotas"`
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, exporters := projects.get()
+		if project := r.URL.Query().Get("project"); project != "" {
+			exporter, ok := exporters[project]
+			if !ok {
+				http.Error(w, "unknown project "+project, http.StatusNotFound)
+				return
+			}
+			exporters = map[string]*Exporter{project: exporter}
+		}
+
+		data := struct {
+			Projects []projectQuotas `json:"projects"`
+		}{Projects: []projectQuotas{}}
+		for name, exporter := range exporters {
+			quotas, fetched, ok := exporter.latestQuotas()
+			if !ok {
+				continue
+			}
+			data.Projects = append(data.Projects, projectQuotas{Project: name, Time: fetched, Quotas: quotas})
+		}
+		if len(exporters) == 1 && len(data.Projects) == 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+			http.Error(w, "project not scraped yet", http.StatusServiceUnavailable)
+			return
+		}
+		sort.Slice(data.Projects, func(i, j int) bool { return data.Projects[i].Project < data.Projects[j].Project })
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(data); err != nil {
+			log.Errorf("Couldn't encode quotas: %v", err)
+		}
+	})
+}
+
+// lifecycleHandler runs action on POST or PUT requests, as the Prometheus /-/quit and /-/reload endpoints do.
+func lifecycleHandler(action func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			w.Header().Set("Allow", "POST, PUT")
+			http.Error(w, "Only POST or PUT requests allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := action(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("OK\n"))
+	})
+}
+
+// snapshotHandler writes the snapshot on request, e.g. from the preStop hook of the pod, so the replica
+// replacing it starts with the data fetched until the end. GET is allowed too, for httpGet hooks.
+func snapshotHandler(save func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost && r.Method != http.MethodPut {
+			w.Header().Set("Allow", "GET, POST, PUT")
+			http.Error(w, "Only GET, POST or PUT requests allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := save(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("OK\n"))
+	})
+}
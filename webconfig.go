@@ -0,0 +1,297 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// webConfig is the --web.config.file content, using the same format as the
+// Prometheus exporter-toolkit.
+type webConfig struct {
+	TLSConfig      tlsServerConfig   `yaml:"tls_server_config"`
+	BasicAuthUsers map[string]string `yaml:"basic_auth_users"` // bcrypt hashed passwords by user name
+}
+
+type tlsServerConfig struct {
+	CertFile          string   `yaml:"cert_file"`
+	KeyFile           string   `yaml:"key_file"`
+	ClientAuth        string   `yaml:"client_auth_type"`
+	ClientCAs         string   `yaml:"client_ca_file"`
+	ClientAllowedSans []string `yaml:"client_allowed_sans"`
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                           tls.NoClientCert,
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+// loadWebConfig reads the web config file, relative paths are resolved against its directory.
+func loadWebConfig(path string) (*webConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &webConfig{}
+	if err := yaml.UnmarshalStrict(content, cfg); err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(path)
+	cfg.TLSConfig.CertFile = resolvePath(dir, cfg.TLSConfig.CertFile)
+	cfg.TLSConfig.KeyFile = resolvePath(dir, cfg.TLSConfig.KeyFile)
+	cfg.TLSConfig.ClientCAs = resolvePath(dir, cfg.TLSConfig.ClientCAs)
+	return cfg, nil
+}
+
+func resolvePath(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// tlsConfig builds the server TLS config, nil when TLS is not configured.
+func (c *webConfig) tlsConfig() (*tls.Config, error) {
+	if c.TLSConfig.CertFile == "" && c.TLSConfig.KeyFile == "" {
+		return nil, nil
+	}
+	if c.TLSConfig.CertFile == "" || c.TLSConfig.KeyFile == "" {
+		return nil, errors.New("both cert_file and key_file must be set in tls_server_config")
+	}
+	cert, err := tls.LoadX509KeyPair(c.TLSConfig.CertFile, c.TLSConfig.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	clientAuth, ok := clientAuthTypes[c.TLSConfig.ClientAuth]
+	if !ok {
+		return nil, fmt.Errorf("invalid client_auth_type %q", c.TLSConfig.ClientAuth)
+	}
+	tlsConfig.ClientAuth = clientAuth
+
+	if c.TLSConfig.ClientCAs != "" {
+		pem, err := ioutil.ReadFile(c.TLSConfig.ClientCAs)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client_ca_file %s", c.TLSConfig.ClientCAs)
+		}
+		tlsConfig.ClientCAs = pool
+	} else if clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert {
+		return nil, errors.New("client_ca_file is required to verify client certificates")
+	}
+
+	if len(c.TLSConfig.ClientAllowedSans) > 0 {
+		if clientAuth != tls.RequireAndVerifyClientCert {
+			return nil, errors.New("client_allowed_sans requires client_auth_type RequireAndVerifyClientCert")
+		}
+		tlsConfig.VerifyPeerCertificate = c.verifyClientSANs
+	}
+	return tlsConfig, nil
+}
+
+// verifyClientSANs accepts the verified client certificate only when one of its
+// subject alternative names is listed in client_allowed_sans.
+func (c *webConfig) verifyClientSANs(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
+		return errors.New("no verified client certificate")
+	}
+	cert := verifiedChains[0][0]
+
+	sans := append([]string{}, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+
+	for _, allowed := range c.TLSConfig.ClientAllowedSans {
+		for _, san := range sans {
+			if san == allowed {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("client certificate SANs %v are not allowed", sans)
+}
+
+// basicAuthHandler requires the basic auth users of the web config, read again on every request.
+type basicAuthHandler struct {
+	webConfigFile string
+	handler       http.Handler
+
+	// Checked credentials, as bcrypt is too slow to run on every scrape. Up to
+	// authCacheSize, so clients trying random passwords don't grow it without bound.
+	cache map[[sha256.Size]byte]bool
+	mutex sync.Mutex
+}
+
+func (h *basicAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cfg, err := loadWebConfig(h.webConfigFile)
+	if err != nil {
+		log.Errorf("Couldn't reload web config: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if len(cfg.BasicAuthUsers) == 0 {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+
+	user, password, ok := r.BasicAuth()
+	if ok {
+		if hash, found := cfg.BasicAuthUsers[user]; found && h.checkPassword(hash, password) {
+			h.handler.ServeHTTP(w, r)
+			return
+		}
+	}
+	w.Header().Set("WWW-Authenticate", "Basic")
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// authCacheSize is the maximum number of credentials checked by a basicAuthHandler kept in its cache.
+const authCacheSize = 100
+
+func (h *basicAuthHandler) checkPassword(hash, password string) bool {
+	key := sha256.Sum256([]byte(hash + "\x00" + password))
+
+	h.mutex.Lock()
+	valid, ok := h.cache[key]
+	h.mutex.Unlock()
+	if ok {
+		return valid
+	}
+
+	valid = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	h.mutex.Lock()
+	if len(h.cache) >= authCacheSize {
+		// Evicts a random entry, the map iteration order being random.
+		for evicted := range h.cache {
+			delete(h.cache, evicted)
+			break
+		}
+	}
+	h.cache[key] = valid
+	h.mutex.Unlock()
+	return valid
+}
+
+// listenAndServe serves server on its address, over TLS and with basic auth when configured
+// in webConfigFile. The web config is read again on every TLS handshake and request, so
+// certificates and users can be changed without restarting the exporter.
+func listenAndServe(server *http.Server, webConfigFile string, systemdSocket bool) error {
+	listener, err := listen(server.Addr, systemdSocket)
+	if err != nil {
+		return err
+	}
+	if webConfigFile == "" {
+		return server.Serve(listener)
+	}
+
+	cfg, err := loadWebConfig(webConfigFile)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+	handler := server.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	server.Handler = &basicAuthHandler{webConfigFile: webConfigFile, handler: handler, cache: make(map[[sha256.Size]byte]bool)}
+
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		listener.Close()
+		return err
+	}
+	if tlsConfig == nil {
+		return server.Serve(listener)
+	}
+
+	log.Infof("TLS is enabled with config %s", webConfigFile)
+	server.TLSConfig = &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg, err := loadWebConfig(webConfigFile)
+			if err != nil {
+				log.Errorf("Couldn't reload web config: %v", err)
+				return nil, err
+			}
+			return cfg.tlsConfig()
+		},
+	}
+	return server.ServeTLS(listener, "", "")
+}
+
+// listen opens the listener of address, which is either a TCP host:port or
+// unix:///path/to/socket for a Unix domain socket. With systemdSocket the
+// listener passed by systemd socket activation is used instead.
+func listen(address string, systemdSocket bool) (net.Listener, error) {
+	if systemdSocket {
+		return systemdListener()
+	}
+	path := strings.TrimPrefix(address, "unix://")
+	if path == address {
+		return net.Listen("tcp", address)
+	}
+	// A socket left behind by an unclean exit would make the listen fail.
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// systemdListener returns the first socket passed by systemd socket activation,
+// see sd_listen_fds(3). The passed sockets start at file descriptor 3.
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, errors.New("no socket passed by systemd, LISTEN_PID isn't set to the exporter's pid")
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, errors.New("no socket passed by systemd, LISTEN_FDS isn't set")
+	}
+	if fds > 1 {
+		log.Warnf("systemd passed %d sockets, only the first one is used", fds)
+	}
+	// Child processes must not pick the sockets up.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	const listenFdsStart = 3
+	syscall.CloseOnExec(listenFdsStart)
+	file := os.NewFile(listenFdsStart, "systemd-socket")
+	defer file.Close()
+	return net.FileListener(file)
+}
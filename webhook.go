@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// defaultWebhookTemplate posts the event as is.
+const defaultWebhookTemplate = "{{ json . }}"
+
+// webhookNotifier POSTs a JSON payload to a webhook URL for every threshold crossing, for the integration with
+// incident tooling. The payload is a Go template over the quotaEvent with the templateFuncs.
+type webhookNotifier struct {
+	url      string
+	template *template.Template
+	headers  map[string]string
+	client   *http.Client
+}
+
+// parseWebhookTemplate parses the payload template of the file, the event as JSON when it is empty. The template
+// is executed for a sample event, so a template not producing JSON fails at startup.
+func parseWebhookTemplate(file string) (*template.Template, error) {
+	text := defaultWebhookTemplate
+	if file != "" {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		text = string(content)
+	}
+	tmpl, err := template.New("webhook").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := webhookPayload(tmpl, quotaEvent{Type: eventThresholdCrossed, Time: time.Now()}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// newWebhookNotifier returns a notifier to webhook, with the payload of tmpl and the comma separated name=value headers.
+func newWebhookNotifier(webhook string, tmpl *template.Template, headers string, timeout time.Duration) (*webhookNotifier, error) {
+	u, err := url.Parse(webhook)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL %q, must be an http or https URL", redactURL(webhook))
+	}
+	parsed, err := parseHeaders(headers)
+	if err != nil {
+		return nil, err
+	}
+	return &webhookNotifier{url: webhook, template: tmpl, headers: parsed, client: &http.Client{Timeout: timeout}}, nil
+}
+
+// templateFuncs are the functions of the notification templates: json quotes a value, percent formats a ratio
+// as a percentage and number formats a limit or usage without an exponent.
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		content, err := json.Marshal(v)
+		return string(content), err
+	},
+	"percent": formatPercent,
+	"number":  formatNumber,
+}
+
+// formatPercent returns a ratio as a percentage with one decimal.
+func formatPercent(ratio float64) string {
+	return strconv.FormatFloat(ratio*100, 'f', 1, 64) + "%"
+}
+
+// formatNumber returns a limit or usage without an exponent.
+func formatNumber(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// webhookPayload executes tmpl for event and checks that the result is JSON.
+func webhookPayload(tmpl *template.Template, event quotaEvent) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, event); err != nil {
+		return nil, err
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("webhook template doesn't produce JSON: %s", strings.TrimSpace(buf.String()))
+	}
+	return buf.Bytes(), nil
+}
+
+// deliver POSTs the payload of every event.
+func (n *webhookNotifier) deliver(ctx context.Context, events []quotaEvent) (int, error) {
+	for i, event := range events {
+		if err := n.send(ctx, event); err != nil {
+			return i, err
+		}
+	}
+	return len(events), nil
+}
+
+// send POSTs the payload of event. Only the transport errors, the timeouts, the throttling and the server
+// errors are retried, the event is rejected on a payload which can't be built or any other response.
+func (n *webhookNotifier) send(ctx context.Context, event quotaEvent) error {
+	body, err := webhookPayload(n.template, event)
+	if err != nil {
+		return &rejectedError{err}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, value := range n.headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			urlErr.URL = redactURL(n.url)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	err = fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	switch {
+	case resp.StatusCode/100 == 5, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusRequestTimeout:
+		return err
+	}
+	return &rejectedError{err}
+}
+
+// redactURL returns a URL for the logs, without its query and password, which often hold the secret of a webhook.
+func redactURL(webhook string) string {
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return "webhook"
+	}
+	u.RawQuery = ""
+	return u.Redacted()
+}